@@ -1,86 +1,105 @@
 package cert
 
 import (
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/sha1"
+	"crypto/tls"
 	"crypto/x509"
-	"crypto/x509/pkix"
 	"encoding/pem"
 	"fmt"
 	"log"
-	"math/big"
-	"os"
-	"path/filepath"
-	"time"
 )
 
-func CreateCACert(pkiPath, cn string, validity time.Duration) error {
-	key, err := rsa.GenerateKey(rand.Reader, 2048)
+func CreateCACert(store KeyStore, cn string, profile Profile) error {
+	return createCACert(store, "", cn, profile)
+}
+
+// CreateIntermediateCACert creates a CA certificate signed by the root CA,
+// so that the root key can be kept offline. The root profile must allow
+// intermediate CAs with a non-zero MaxPathLen.
+func CreateIntermediateCACert(store KeyStore, root, cn string, profile Profile) error {
+	return createCACert(store, root, cn, profile)
+}
+
+// createCACert creates a CA certificate signed by issuer,
+// or a self-signed CA certificate when issuer is empty.
+func createCACert(store KeyStore, issuer, cn string, profile Profile) error {
+	cert, err := profile.template(cn)
 	if err != nil {
-		return fmt.Errorf("generate key: %v", err)
+		return fmt.Errorf("create template: %v", err)
 	}
 
-	subjectKeyId := sha1.Sum([]byte("CN=" + cn + ",O=washingmachine,ST=france,C=EU"))
-
-	cert := x509.Certificate{
-		SerialNumber:          big.NewInt(10000),
-		IsCA:                  true,
-		MaxPathLenZero:        true,
-		BasicConstraintsValid: true,
-		SubjectKeyId:          subjectKeyId[:],
-		Subject: pkix.Name{
-			CommonName:   cn,
-			Organization: []string{"washingmachine"},
-			Province:     []string{"france"},
-			Country:      []string{"EU"},
-		},
-		NotBefore: time.Now(),
-		NotAfter:  time.Now().Add(validity),
-		KeyUsage:  x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	var tlsCA tls.Certificate
+	if issuer != "" {
+		tlsCA, err = ReadTLSCert(store, issuer, issuer)
+		if err != nil {
+			return fmt.Errorf("read ca cert: %v", err)
+		}
 	}
 
-	der, err := x509.CreateCertificate(
-		rand.Reader,
-		&cert,
-		&cert,
-		&key.PublicKey,
-		key,
-	)
+	key, err := GenerateKey(profile.KeyType)
 	if err != nil {
-		return fmt.Errorf("encode cert: %v", err)
+		return fmt.Errorf("generate key: %v", err)
 	}
-	block := pem.Block{Type: "CERTIFICATE", Bytes: der}
 
-	err = SaveKey(pkiPath, cn, cn, key)
+	cert.SerialNumber, err = newSerialNumber()
 	if err != nil {
-		return fmt.Errorf("save key: %v", err)
+		return fmt.Errorf("generate serial number: %v", err)
+	}
+	cert.IsCA = true
+	cert.BasicConstraintsValid = true
+	cert.MaxPathLen = profile.MaxPathLen
+	cert.MaxPathLenZero = profile.MaxPathLen == 0
+	profile.NameConstraints.set(&cert)
+	if cert.KeyUsage == 0 {
+		cert.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
 	}
 
-	err = SaveCertBlock(pkiPath, cn, &block)
+	if issuer == "" {
+		issuer = cn
+		tlsCA = tls.Certificate{PrivateKey: key, Leaf: &cert}
+	} else {
+		if err := checkPathLen(tlsCA.Leaf, &cert); err != nil {
+			return err
+		}
+		if err := checkIssuerConstraints(store, issuer, &cert); err != nil {
+			return err
+		}
+	}
+
+	blocks, err := signCert(&cert, key.Public(), tlsCA)
 	if err != nil {
-		return fmt.Errorf("save cert: %v", err)
+		return err
 	}
 
+	return saveCert(store, issuer, cn, cn, key, blocks, false)
+}
+
+// checkPathLen refuses the intermediate CA certificates that the path
+// length constraint of their issuer would reject at verification.
+func checkPathLen(issuer, cert *x509.Certificate) error {
+	if issuer.MaxPathLen < 0 {
+		return nil
+	}
+	if issuer.MaxPathLen == 0 {
+		return policyErrorf("%q does not allow intermediate CAs", issuer.Subject.CommonName)
+	}
+	if cert.MaxPathLen < 0 || cert.MaxPathLen >= issuer.MaxPathLen {
+		return policyErrorf("max path len of %q must be less than %d", cert.Subject.CommonName, issuer.MaxPathLen)
+	}
 	return nil
 }
 
-func SaveCertBlock(pkiPath, cn string, block *pem.Block) error {
-	path := filepath.Join(pkiPath, cn+".crt")
-	log.Printf("writing %q certificate to %q\n", cn, path)
+func SaveCertBlock(store KeyStore, cn string, blocks ...*pem.Block) error {
+	name := cn + ".crt"
+	log.Printf("writing %q certificate to %q\n", cn, name)
 
-	file, err := os.Create(path)
-	if err != nil {
-		return err
+	var data []byte
+	for _, block := range blocks {
+		data = append(data, pem.EncodeToMemory(block)...)
 	}
 
-	if err = pem.Encode(file, block); err != nil {
+	if err := store.WriteFile(name, data, 0644); err != nil {
 		return fmt.Errorf("write cert: %v", err)
 	}
 
-	if err = file.Close(); err != nil {
-		return err
-	}
-
 	return nil
 }
@@ -0,0 +1,214 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// maxCSRSize limits the size of the certificate requests.
+const maxCSRSize = 64 << 10
+
+// CAHandler serves the ca of store:
+//
+//	GET  /ca.crt       the certificate chain of the CA
+//	GET  /ca.crl       the CRL of the CA
+//	POST /sign/server  signs a PEM encoded server certificate request
+//	POST /sign/client  signs a PEM encoded client certificate request
+//
+// Signing requires a client certificate verified by the TLS server, the
+// requests are checked against the policy and issued with the profiles.
+// A client certificate request renews the identity of the requester: its
+// common name, email addresses and URIs must be the ones of the requester.
+// The server certificates are only signed for the requesters listed in
+// the ServerRequesters of the policy.
+func CAHandler(store KeyStore, ca string, profiles Profiles, policy Policy) http.Handler {
+	return &caHandler{
+		store:    store,
+		ca:       ca,
+		profiles: profiles,
+		policy:   policy,
+	}
+}
+
+type caHandler struct {
+	store    KeyStore
+	ca       string
+	profiles Profiles
+	policy   Policy
+}
+
+func (h *caHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var next http.Handler
+
+	switch r.URL.Path {
+	case "/ca.crt":
+		next = h.serveFile(h.ca+".crt", "application/pem-certificate-chain")
+	case "/ca.crl":
+		next = h.serveFile(h.ca+".crl", "application/pkix-crl")
+	case "/sign/server":
+		next = h.sign(ServerLeaf, h.profiles.Server)
+	case "/sign/client":
+		next = h.sign(ClientLeaf, h.profiles.Client)
+	default:
+		next = http.NotFoundHandler()
+	}
+
+	next.ServeHTTP(w, r)
+}
+
+func (h *caHandler) serveFile(name, contentType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		data, err := h.store.ReadFile(name)
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Write(data)
+	}
+}
+
+func (h *caHandler) sign(kind LeafKind, profile Profile) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			http.Error(w, "client certificate required", http.StatusUnauthorized)
+			return
+		}
+		requester := r.TLS.VerifiedChains[0][0]
+
+		data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxCSRSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		csr, err := ParseCSR(data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if csr.Subject.CommonName == "" {
+			http.Error(w, "missing common name", http.StatusBadRequest)
+			return
+		}
+
+		switch kind {
+		case ServerLeaf:
+			err = h.policy.checkServerRequester(requester)
+		case ClientLeaf:
+			err = checkClientRequester(requester, csr)
+		}
+		if err != nil {
+			log.Printf("%v: sign %s csr: %v\n", requester.Subject, kind, err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		chain, err := IssueCSR(h.store, h.ca, kind, data, profile, h.policy)
+		if err != nil {
+			log.Printf("%v: sign %s csr: %v\n", requester.Subject, kind, err)
+
+			status := http.StatusInternalServerError
+			if _, ok := err.(*PolicyError); ok {
+				status = http.StatusForbidden
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		log.Printf("%v: signed %s csr\n", requester.Subject, kind)
+
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(chain)
+	}
+}
+
+// requesterNames returns the common name, the email addresses and the URIs
+// of the requester.
+func requesterNames(requester *x509.Certificate) map[string]bool {
+	names := map[string]bool{requester.Subject.CommonName: true}
+	for _, email := range requester.EmailAddresses {
+		names[email] = true
+	}
+	for _, uri := range requester.URIs {
+		names[uri.String()] = true
+	}
+	return names
+}
+
+func (p Policy) checkServerRequester(requester *x509.Certificate) error {
+	names := requesterNames(requester)
+	for _, name := range p.ServerRequesters {
+		if names[name] {
+			return nil
+		}
+	}
+	return policyErrorf("requester not allowed to sign server certificates: %q", requester.Subject.CommonName)
+}
+
+// checkClientRequester restricts the identity of the client certificate
+// request to the one of the requester.
+func checkClientRequester(requester *x509.Certificate, csr *x509.CertificateRequest) error {
+	if cn := csr.Subject.CommonName; cn != requester.Subject.CommonName {
+		return policyErrorf("common name of another requester: %q", cn)
+	}
+
+	names := requesterNames(requester)
+	for _, email := range csr.EmailAddresses {
+		if !names[email] {
+			return policyErrorf("email address of another requester: %q", email)
+		}
+	}
+	for _, uri := range csr.URIs {
+		if !names[uri.String()] {
+			return policyErrorf("uri of another requester: %q", uri)
+		}
+	}
+	return nil
+}
+
+// RequestCert sends a PEM encoded certificate request to the CAHandler
+// served at baseURL and returns the PEM encoded certificate chain.
+// The client must present a certificate trusted by the CA server.
+func RequestCert(client *http.Client, baseURL string, kind LeafKind, csr []byte) ([]byte, error) {
+	url := strings.TrimSuffix(baseURL, "/") + "/sign/" + string(kind)
+
+	res, err := client.Post(url, "application/pkcs10", bytes.NewReader(csr))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", res.Status, bytes.TrimSpace(data))
+	}
+
+	return data, nil
+}
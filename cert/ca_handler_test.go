@@ -0,0 +1,155 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCAHandler(t *testing.T) {
+	store := NewMemStore()
+
+	err := CreateCerts(store, "ca", "localhost", "client", profiles)
+	if err != nil {
+		t.Fatalf("create certs: %v", err)
+	}
+
+	policy := Policy{
+		DNSSuffixes:      []string{"washingmachine.test"},
+		MaxValidity:      time.Hour,
+		ServerRequesters: []string{"client"},
+	}
+	if err := CreateClientCert(store, "ca", "bob", profiles.Client); err != nil {
+		t.Fatalf("create client cert: %v", err)
+	}
+	handler := http.StripPrefix("/ca", CAHandler(store, "ca", profiles, policy))
+
+	newClientCSR := func(cn string, profile Profile) []byte {
+		key, err := GenerateKey(ECDSAP256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := NewCSR(key, cn, profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+	}
+	newCSR := func(names ...string) []byte {
+		return newClientCSR("ci", Profile{DNSNames: names})
+	}
+
+	withHandler(store, handler, func(url string) {
+		client, err := NewTLSClient(store, "ca", "client")
+		if err != nil {
+			t.Fatalf("create client: %v", err)
+		}
+
+		data, err := RequestCert(client, url+"/ca", ServerLeaf, newCSR("ci.washingmachine.test"))
+		if err != nil {
+			t.Fatalf("request cert: %v", err)
+		}
+		chain, err := ParseCertChain(data)
+		if err != nil {
+			t.Fatalf("parse chain: %v", err)
+		}
+		if chain[0].DNSNames[0] != "ci.washingmachine.test" {
+			t.Fatalf("unexpected dns names: %v", chain[0].DNSNames)
+		}
+
+		_, err = RequestCert(client, url+"/ca", ServerLeaf, newCSR("www.production.test"))
+		if err == nil || !strings.HasPrefix(err.Error(), "403") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		_, err = RequestCert(client, url+"/ca", ClientLeaf, []byte("garbage"))
+		if err == nil || !strings.HasPrefix(err.Error(), "400") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, err = RequestCert(client, url+"/ca", ClientLeaf, newClientCSR("", Profile{}))
+		if err == nil || !strings.HasPrefix(err.Error(), "400") {
+			t.Fatalf("missing common name: %v", err)
+		}
+
+		// the client certificates renew the identity of the requester
+		bob, err := NewTLSClient(store, "ca", "bob")
+		if err != nil {
+			t.Fatalf("create client: %v", err)
+		}
+		if _, err := RequestCert(bob, url+"/ca", ClientLeaf, newClientCSR("bob", Profile{})); err != nil {
+			t.Fatalf("request own cert: %v", err)
+		}
+		for name, csr := range map[string][]byte{
+			"cn":    newClientCSR("client", Profile{}),
+			"email": newClientCSR("bob", Profile{EmailAddresses: []string{"alice@washingmachine.test"}}),
+			"uri":   newClientCSR("bob", Profile{URIs: []*neturl.URL{{Scheme: "spiffe", Host: "washingmachine.test", Path: "/billing"}}}),
+		} {
+			_, err = RequestCert(bob, url+"/ca", ClientLeaf, csr)
+			if err == nil || !strings.HasPrefix(err.Error(), "403") {
+				t.Fatalf("%s of another requester: %v", name, err)
+			}
+		}
+
+		// bob is not allowed to request server certificates
+		_, err = RequestCert(bob, url+"/ca", ServerLeaf, newCSR("ci.washingmachine.test"))
+		if err == nil || !strings.HasPrefix(err.Error(), "403") {
+			t.Fatalf("server cert of bob: %v", err)
+		}
+
+		// without client certificate
+		anonymous, err := NewTLSClient(store, "ca", "client")
+		if err != nil {
+			t.Fatalf("create client: %v", err)
+		}
+		anonymous.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{}
+
+		_, err = RequestCert(anonymous, url+"/ca", ServerLeaf, newCSR("ci.washingmachine.test"))
+		if err == nil || !strings.HasPrefix(err.Error(), "401") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		res, err := anonymous.Get(url + "/ca/ca.crt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		data, err = ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := store.ReadFile("ca.crt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, expected) {
+			t.Fatalf("unexpected ca cert: %s", data)
+		}
+
+		res, err = anonymous.Get(url + "/ca/ca.crl")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusNotFound {
+			t.Fatalf("unexpected status: %v", res.Status)
+		}
+
+		if err := GenerateCRL(store, "ca", time.Hour); err != nil {
+			t.Fatalf("generate crl: %v", err)
+		}
+		res, err = anonymous.Get(url + "/ca/ca.crl")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status: %v", res.Status)
+		}
+	})
+}
@@ -0,0 +1,131 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/x509"
+	"io/ioutil"
+	"testing"
+)
+
+func TestIntermediateCA(t *testing.T) {
+	store := NewMemStore()
+
+	root := profiles.CA
+	root.MaxPathLen = 1
+	if err := CreateCACert(store, "ca", root); err != nil {
+		t.Fatalf("create root cert: %v", err)
+	}
+	if err := CreateIntermediateCACert(store, "ca", "issuing", profiles.CA); err != nil {
+		t.Fatalf("create intermediate cert: %v", err)
+	}
+	if err := CreateServerCert(store, "issuing", "localhost", profiles.Server); err != nil {
+		t.Fatalf("create server cert: %v", err)
+	}
+	if err := CreateClientCert(store, "issuing", "client", profiles.Client); err != nil {
+		t.Fatalf("create client cert: %v", err)
+	}
+
+	for name, length := range map[string]int{"ca": 1, "issuing": 1, "localhost": 2, "client": 2} {
+		chain, err := ReadCertChain(store, name)
+		if err != nil {
+			t.Fatalf("read %s chain: %v", name, err)
+		}
+		if len(chain) != length {
+			t.Fatalf("unexpected %s chain length: %d", name, len(chain))
+		}
+		if length == 2 && chain[1].Subject.CommonName != "issuing" {
+			t.Fatalf("unexpected %s chain: %v", name, chain[1].Subject)
+		}
+	}
+
+	tlsServer, err := ReadTLSCert(store, "localhost", "")
+	if err != nil {
+		t.Fatalf("read server cert: %v", err)
+	}
+	if len(tlsServer.Certificate) != 2 || tlsServer.Leaf.Subject.CommonName != "localhost" {
+		t.Fatalf("unexpected server cert: %v", tlsServer.Leaf.Subject)
+	}
+
+	record, err := FindRecord(store, tlsServer.Leaf.SerialNumber)
+	if err != nil {
+		t.Fatalf("find record: %v", err)
+	}
+	if record.Issuer != "issuing" {
+		t.Fatalf("unexpected issuer: %v", record.Issuer)
+	}
+
+	// the server and the client only trust the root
+	withServerPKI(store, func(url string) {
+		client, err := NewTLSClient(store, "ca", "client")
+		if err != nil {
+			t.Fatalf("create client: %v", err)
+		}
+
+		res, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		data, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		greeting := string(data)
+
+		if greeting != "hello client@washingmachine" {
+			t.Fatalf("unexpected greeting: %q", greeting)
+		}
+	})
+}
+
+func TestIntermediatePathLen(t *testing.T) {
+	store := NewMemStore()
+
+	root := profiles.CA
+	root.MaxPathLen = 1
+	if err := CreateCACert(store, "root", root); err != nil {
+		t.Fatalf("create root cert: %v", err)
+	}
+
+	unlimited := profiles.CA
+	unlimited.MaxPathLen = -1
+	if err := CreateIntermediateCACert(store, "root", "unlimited", unlimited); err == nil {
+		t.Fatal("intermediate exceeds the path length of the root")
+	}
+
+	if err := CreateIntermediateCACert(store, "root", "ca", profiles.CA); err != nil {
+		t.Fatalf("create intermediate cert: %v", err)
+	}
+	err := CreateIntermediateCACert(store, "ca", "issuing", profiles.CA)
+	if _, ok := err.(*PolicyError); !ok {
+		t.Fatalf("intermediate below a max path len 0: %v", err)
+	}
+}
+
+func TestSubjectKeyId(t *testing.T) {
+	// two CAs with the same subject, like a rotated CA
+	var cas []*x509.Certificate
+	for i := 0; i < 2; i++ {
+		store := NewMemStore()
+		if err := CreateCerts(store, "ca", "localhost", "client", profiles); err != nil {
+			t.Fatalf("create certs: %v", err)
+		}
+		chain, err := ReadCertChain(store, "localhost")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ca, err := ReadCert(store, "ca")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ca.SubjectKeyId) == 0 || !bytes.Equal(chain[0].AuthorityKeyId, ca.SubjectKeyId) {
+			t.Fatalf("unexpected key ids: %X %X", ca.SubjectKeyId, chain[0].AuthorityKeyId)
+		}
+		cas = append(cas, ca)
+	}
+
+	if bytes.Equal(cas[0].SubjectKeyId, cas[1].SubjectKeyId) {
+		t.Fatalf("same subject key id: %X", cas[0].SubjectKeyId)
+	}
+}
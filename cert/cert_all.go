@@ -2,19 +2,18 @@ package cert
 
 import (
 	"fmt"
-	"time"
 )
 
-func CreateCerts(pkiPath, ca, server, client string, validity time.Duration) error {
-	if err := CreateCACert(pkiPath, ca, validity); err != nil {
+func CreateCerts(store KeyStore, ca, server, client string, profiles Profiles) error {
+	if err := CreateCACert(store, ca, profiles.CA); err != nil {
 		return fmt.Errorf("create ca cert: %v", err)
 	}
 
-	if err := CreateServerCert(pkiPath, ca, server, validity); err != nil {
+	if err := CreateServerCert(store, ca, server, profiles.Server); err != nil {
 		return fmt.Errorf("create server cert: %v", err)
 	}
 
-	if err := CreateClientCert(pkiPath, ca, client, validity); err != nil {
+	if err := CreateClientCert(store, ca, client, profiles.Client); err != nil {
 		return fmt.Errorf("create client cert: %v", err)
 	}
 
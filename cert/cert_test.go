@@ -1,38 +1,63 @@
 package cert
 
 import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/tls"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
-	"os"
-	"path"
 	"testing"
 	"time"
 )
 
 var (
-	pkiPath  = path.Dir(os.Args[0])
-	validity = 1 * time.Minute
+	pki      = NewMemStore()
+	validity = 1 * time.Hour // outlives the suite under -race
+	profiles = newProfiles(RSA2048)
 )
 
+func newProfiles(keyType KeyType) Profiles {
+	p := Profile{
+		KeyType:      keyType,
+		Validity:     validity,
+		Organization: []string{"washingmachine"},
+		Province:     []string{"france"},
+		Country:      []string{"EU"},
+	}
+	profiles := Profiles{CA: p, Server: p, Client: p}
+	profiles.Server.DNSNames = []string{"localhost"}
+	profiles.Server.IPAddresses = []net.IP{net.IPv6loopback, net.IPv4(127, 0, 0, 1)}
+	profiles.Client.EmailAddresses = []string{"client@washingmachine"}
+	return profiles
+}
+
 func init() {
 	log.SetFlags(log.Lshortfile)
 	// log.SetOutput(os.Stderr)
 	log.SetOutput(ioutil.Discard)
 
-	err := CreateCerts(pkiPath, "ca", "localhost", "client", validity)
+	err := CreateCerts(pki, "ca", "localhost", "client", profiles)
 	if err != nil {
 		log.Fatalf("create certs: %v", err)
 	}
 }
 
 func withServer(fn func(string)) {
-	server := httptest.NewUnstartedServer(HelloHandler("world"))
+	withServerPKI(pki, fn)
+}
+
+func withServerPKI(store KeyStore, fn func(string), opts ...TLSOption) {
+	withHandler(store, HelloHandler("world"), fn, opts...)
+}
+
+func withHandler(store KeyStore, handler http.Handler, fn func(string), opts ...TLSOption) {
+	server := httptest.NewUnstartedServer(handler)
 	defer server.Close()
 
-	tlsConfig, err := NewTLSConfig(pkiPath, "ca", "localhost")
+	tlsConfig, err := NewTLSConfig(store, "ca", "localhost", opts...)
 	if err != nil {
 		log.Fatalf("create tls config: %v", err)
 	}
@@ -45,7 +70,7 @@ func withServer(fn func(string)) {
 
 func TestClientAuth(t *testing.T) {
 	withServer(func(url string) {
-		client, err := NewTLSClient(pkiPath, "ca", "client")
+		client, err := NewTLSClient(pki, "ca", "client")
 		if err != nil {
 			t.Fatalf("create client: %v", err)
 		}
@@ -70,7 +95,7 @@ func TestClientAuth(t *testing.T) {
 
 func TestClientNoAuth(t *testing.T) {
 	withServer(func(url string) {
-		client, err := NewTLSClient(pkiPath, "ca", "client")
+		client, err := NewTLSClient(pki, "ca", "client")
 		if err != nil {
 			t.Fatalf("create client: %v", err)
 		}
@@ -97,3 +122,58 @@ func TestClientNoAuth(t *testing.T) {
 		}
 	})
 }
+
+func TestKeyTypes(t *testing.T) {
+	for _, keyType := range []KeyType{ECDSAP256, ECDSAP384, Ed25519} {
+		store := NewMemStore()
+
+		err := CreateCerts(store, "ca", "localhost", "client", newProfiles(keyType))
+		if err != nil {
+			t.Fatalf("%s: create certs: %v", keyType, err)
+		}
+
+		key, err := ReadKey(store, "client", "")
+		if err != nil {
+			t.Fatalf("%s: read key: %v", keyType, err)
+		}
+		if _, err := ReadKey(store, "ca", "ca"); err != nil {
+			t.Fatalf("%s: read ca key: %v", keyType, err)
+		}
+
+		switch key.(type) {
+		case *ecdsa.PrivateKey:
+			if keyType == Ed25519 {
+				t.Fatalf("%s: unexpected key: %T", keyType, key)
+			}
+		case ed25519.PrivateKey:
+			if keyType != Ed25519 {
+				t.Fatalf("%s: unexpected key: %T", keyType, key)
+			}
+		default:
+			t.Fatalf("%s: unexpected key: %T", keyType, key)
+		}
+
+		withServerPKI(store, func(url string) {
+			client, err := NewTLSClient(store, "ca", "client")
+			if err != nil {
+				t.Fatalf("%s: create client: %v", keyType, err)
+			}
+
+			res, err := client.Get(url)
+			if err != nil {
+				t.Fatalf("%s: %v", keyType, err)
+			}
+			defer res.Body.Close()
+
+			data, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			greeting := string(data)
+
+			if greeting != "hello client@washingmachine" {
+				t.Fatalf("%s: unexpected greeting: %q", keyType, greeting)
+			}
+		})
+	}
+}
@@ -1,68 +1,66 @@
 package cert
 
 import (
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/sha1"
 	"crypto/x509"
-	"crypto/x509/pkix"
-	"encoding/pem"
 	"fmt"
-	"math/big"
-	"time"
 )
 
-func CreateClientCert(pkiPath, ca, client string, validity time.Duration) error {
-	tlsCA, err := ReadTLSCert(pkiPath, ca, ca)
-	if err != nil {
-		return fmt.Errorf("read ca cert: %v", err)
+func CreateClientCert(store KeyStore, ca, client string, profile Profile) error {
+	return createClientCert(store, ca, client, profile, false)
+}
+
+// RenewClientCert issues a new client certificate and replaces the existing
+// key and certificate files, each one atomically.
+func RenewClientCert(store KeyStore, ca, client string, profile Profile) error {
+	return createClientCert(store, ca, client, profile, true)
+}
+
+func createClientCert(store KeyStore, ca, client string, profile Profile, replace bool) error {
+	if replace {
+		if err := checkReplace(store, client, ClientLeaf); err != nil {
+			return err
+		}
 	}
 
-	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	cert, err := profile.template(client)
 	if err != nil {
-		return fmt.Errorf("generate key: %v", err)
+		return fmt.Errorf("create template: %v", err)
 	}
 
-	subjectKeyId := sha1.Sum([]byte("CN=" + client + ",O=washingmachine,ST=france,C=EU"))
-
-	cert := x509.Certificate{
-		SerialNumber: big.NewInt(12000),
-		SubjectKeyId: subjectKeyId[:],
-		Subject: pkix.Name{
-			CommonName:   client,
-			Organization: []string{"washingmachine"},
-			Province:     []string{"france"},
-			Country:      []string{"EU"},
-		},
-		NotBefore:   time.Now(),
-		NotAfter:    time.Now().Add(validity),
-		KeyUsage:    x509.KeyUsageDigitalSignature,
-		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
-		//
-		EmailAddresses: []string{client + "@washingmachine"},
+	tlsCA, err := ReadTLSCert(store, ca, ca)
+	if err != nil {
+		return fmt.Errorf("read ca cert: %v", err)
 	}
 
-	der, err := x509.CreateCertificate(
-		rand.Reader,
-		&cert,
-		tlsCA.Leaf,
-		&key.PublicKey,
-		tlsCA.PrivateKey,
-	)
+	key, err := GenerateKey(profile.KeyType)
 	if err != nil {
-		return fmt.Errorf("encode cert: %v", err)
+		return fmt.Errorf("generate key: %v", err)
 	}
-	block := pem.Block{Type: "CERTIFICATE", Bytes: der}
 
-	err = SaveKey(pkiPath, client, "", key)
+	cert.SerialNumber, err = newSerialNumber()
 	if err != nil {
-		return fmt.Errorf("save key: %v", err)
+		return fmt.Errorf("generate serial number: %v", err)
+	}
+	setClientUsage(&cert)
+
+	if err := checkIssuerConstraints(store, ca, &cert); err != nil {
+		return err
 	}
 
-	err = SaveCertBlock(pkiPath, client, &block)
+	blocks, err := signCert(&cert, key.Public(), tlsCA)
 	if err != nil {
-		return fmt.Errorf("save cert: %v", err)
+		return err
 	}
 
-	return nil
+	return saveCert(store, ca, client, "", key, blocks, replace)
+}
+
+// setClientUsage sets the default key usages of client certificates.
+func setClientUsage(cert *x509.Certificate) {
+	if cert.KeyUsage == 0 {
+		cert.KeyUsage = x509.KeyUsageDigitalSignature
+	}
+	if cert.ExtKeyUsage == nil {
+		cert.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
 }
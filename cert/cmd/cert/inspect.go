@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/schorlet/exp/cert"
+)
+
+// readChain reads the certificates of a PEM file,
+// or of a name of the PKI when there is no such file.
+func readChain(arg string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(arg)
+	if os.IsNotExist(err) {
+		return cert.ReadCertChain(store, arg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cert.ParseCertChain(data)
+}
+
+func inspect(args []string) error {
+	fs := newFlagSet("inspect")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return usageError(fs)
+	}
+
+	chain, err := readChain(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	summaries := make([]cert.Summary, len(chain))
+	for i, c := range chain {
+		summaries[i] = cert.Inspect(c)
+	}
+
+	return output(summaries, func(w io.Writer) {
+		for i, s := range summaries {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "subject:\t%s\n", s.Subject)
+			fmt.Fprintf(w, "issuer:\t%s\n", s.Issuer)
+			fmt.Fprintf(w, "serial:\t%s\n", s.Serial)
+			if sans := s.SANs(); len(sans) != 0 {
+				fmt.Fprintf(w, "sans:\t%s\n", strings.Join(sans, ", "))
+			}
+			fmt.Fprintf(w, "key:\t%s %d\n", s.KeyAlgorithm, s.KeySize)
+			if len(s.KeyUsage) != 0 {
+				fmt.Fprintf(w, "key usage:\t%s\n", strings.Join(s.KeyUsage, ", "))
+			}
+			if len(s.ExtKeyUsage) != 0 {
+				fmt.Fprintf(w, "ext key usage:\t%s\n", strings.Join(s.ExtKeyUsage, ", "))
+			}
+			if s.IsCA {
+				fmt.Fprintf(w, "ca:\tmax path len %d, self signed %t\n", s.MaxPathLen, s.SelfSigned)
+			}
+			fmt.Fprintf(w, "validity:\t%s - %s\n",
+				s.NotBefore.Format(time.RFC3339), s.NotAfter.Format(time.RFC3339))
+			fmt.Fprintf(w, "sha1 fingerprint:\t%s\n", s.SHA1Fingerprint)
+			fmt.Fprintf(w, "sha256 fingerprint:\t%s\n", s.SHA256Fingerprint)
+			fmt.Fprintf(w, "spki sha256:\t%s\n", s.SPKISHA256)
+		}
+	})
+}
+
+func verify(args []string) error {
+	fs := newFlagSet("verify")
+	ca := fs.String("ca", "ca", "name of the trusted CA")
+	usage := fs.String("usage", "any", "expected usage: server, client or any")
+	host := fs.String("host", "", "expected DNS name or IP address of a server certificate")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return usageError(fs)
+	}
+
+	chain, err := readChain(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	roots, err := cert.NewCertPool(store, *ca, false)
+	if err != nil {
+		return err
+	}
+
+	var keyUsage x509.ExtKeyUsage
+	switch *usage {
+	case "server":
+		keyUsage = x509.ExtKeyUsageServerAuth
+	case "client":
+		keyUsage = x509.ExtKeyUsageClientAuth
+	case "any":
+		keyUsage = x509.ExtKeyUsageAny
+	default:
+		return usageError(fs)
+	}
+
+	result := struct {
+		Valid   bool       `json:"valid"`
+		Reasons []string   `json:"reasons,omitempty"`
+		Chains  [][]string `json:"chains,omitempty"`
+	}{}
+
+	chains, err := cert.Verify(chain[0], chain[1:], roots, keyUsage, *host)
+	if verr, ok := err.(*cert.VerifyError); ok {
+		result.Reasons = verr.Reasons
+	} else if err != nil {
+		return err
+	} else {
+		result.Valid = true
+	}
+	for _, verified := range chains {
+		var subjects []string
+		for _, c := range verified {
+			subjects = append(subjects, c.Subject.String())
+		}
+		result.Chains = append(result.Chains, subjects)
+	}
+
+	if err := output(result, func(w io.Writer) {
+		for _, reason := range result.Reasons {
+			fmt.Fprintf(w, "invalid:\t%s\n", reason)
+		}
+		for _, subjects := range result.Chains {
+			fmt.Fprintf(w, "valid:\t%s\n", strings.Join(subjects, " <- "))
+		}
+	}); err != nil {
+		return err
+	}
+
+	if !result.Valid {
+		os.Exit(1)
+	}
+	return nil
+}
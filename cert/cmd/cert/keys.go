@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/schorlet/exp/cert"
+)
+
+func p12(args []string) error {
+	fs := newFlagSet("p12")
+	password := fs.String("password", "", "password of the PKCS#12 file")
+	legacy := fs.Bool("legacy", false, "export with legacy algorithms")
+	keyPassword := fs.String("key-password", "", "password of the PEM key, CA keys are encrypted with their name")
+	if len(args) == 0 {
+		return usageError(fs)
+	}
+	action := args[0]
+	fs.Parse(args[1:])
+	if fs.NArg() != 2 {
+		return usageError(fs)
+	}
+
+	switch action {
+	case "export":
+		data, err := cert.ExportPKCS12(store, fs.Arg(0), *keyPassword, *password, *legacy)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(fs.Arg(1), data, 0600)
+
+	case "import":
+		data, err := ioutil.ReadFile(fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		if err := cert.ImportPKCS12(store, fs.Arg(0), *keyPassword, data, *password); err != nil {
+			return err
+		}
+		return printCert(fs.Arg(0))
+
+	default:
+		return usageError(fs)
+	}
+}
+
+func migrateKeys(args []string) error {
+	fs := newFlagSet("migrate-keys")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		return usageError(fs)
+	}
+
+	// CA keys are encrypted with their name
+	migrated, err := cert.MigrateKeys(store, func(cn string) string { return cn })
+	if migrated == nil {
+		migrated = []string{}
+	}
+
+	if err := output(migrated, func(w io.Writer) {
+		for _, cn := range migrated {
+			fmt.Fprintf(w, "migrated %s.key\n", cn)
+		}
+	}); err != nil {
+		return err
+	}
+
+	return err
+}
@@ -1,23 +1,76 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
-	"net/http"
 	"os"
+	"sort"
+	"text/tabwriter"
 	"time"
 
 	"github.com/schorlet/exp/cert"
 )
 
 var (
-	pkiPath  = flag.String("pki", os.TempDir(), "path to read/write certificates and keys")
-	validity = flag.Duration("validity", 24*time.Hour, "validity lifetime in hours")
-	verbose  = flag.Bool("v", false, "print log messages")
+	pkiPath = flag.String("pki", os.TempDir(), "path to read/write certificates and keys")
+	vault   = flag.String("vault", "", "path to an encrypted vault file used instead of -pki, the password is read from $CERT_VAULT_PASSWORD")
+	profile = flag.String("profile", "", "path to a YAML or JSON file of certificate profiles")
+	jsonOut = flag.Bool("json", false, "print JSON output")
+	verbose = flag.Bool("v", false, "print log messages")
 )
 
+// command is a subcommand of the cert command.
+type command struct {
+	usage string
+	help  string
+	run   func(args []string) error
+}
+
+var commands map[string]command
+
+// store holds the certificates and keys, opened by main.
+var store cert.KeyStore
+
 func init() {
+	// assigned in init, the commands refer to commands
+	commands = map[string]command{
+		"init-ca":      {"[flags] [name]", "create a root CA, or an intermediate CA with -root", initCA},
+		"issue":        {"server|client [flags] <name>", "issue a server or client certificate", issue},
+		"list":         {"[flags]", "list the certificates of the index", list},
+		"inspect":      {"<file|name>", "describe the certificates of a PEM file or of the PKI", inspect},
+		"revoke":       {"[flags] <serial|name>", "revoke the certificates of a serial or a name", revoke},
+		"renew":        {"[flags] <name>", "renew a server or client certificate", renew},
+		"crl":          {"[flags]", "generate the CRL of a CA", crl},
+		"verify":       {"[flags] <file|name>", "verify a certificate against a CA", verify},
+		"serve":        {"[flags]", "serve the CA, OCSP and echo handlers over TLS", serve},
+		"proxy":        {"[flags] <upstream>", "terminate mTLS and forward to an HTTP upstream with the client identity headers", proxy},
+		"p12":          {"export|import [flags] <name> <file>", "export or import PKCS#12 files", p12},
+		"migrate-keys": {"", "re-encrypt the legacy encrypted keys as PKCS#8", migrateKeys},
+	}
+
+	flag.Usage = func() {
+		w := flag.CommandLine.Output()
+		fmt.Fprintf(w, "usage: %s [flags] <command> [args]\n\ncommands:\n", os.Args[0])
+
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		for _, name := range names {
+			fmt.Fprintf(tw, "  %s %s\t%s\n", name, commands[name].usage, commands[name].help)
+		}
+		tw.Flush()
+
+		fmt.Fprintf(w, "\nflags:\n")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
 	log.SetOutput(ioutil.Discard)
@@ -29,21 +82,107 @@ func init() {
 }
 
 func main() {
-	if err := cert.CreateCerts(*pkiPath, "ca", "localhost", "client", *validity); err != nil {
-		log.Fatalf("create certs: %v", err)
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		flag.Usage()
+		os.Exit(2)
 	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", cert.HelloHandler("world"))
-	mux.HandleFunc("/echo", cert.EchoHandler)
-
-	tlsServer, err := cert.NewTLSServer(*pkiPath, "ca", "localhost", ":8443", mux)
+	var err error
+	store, err = openStore()
 	if err != nil {
-		log.Fatalf("create tls server: %v", err)
+		fmt.Fprintf(os.Stderr, "%s: open store: %v\n", os.Args[0], err)
+		os.Exit(1)
+	}
+
+	if err := cmd.run(flag.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: %v\n", os.Args[0], flag.Arg(0), err)
+		os.Exit(1)
+	}
+}
+
+// newFlagSet creates the flag set of a command,
+// which exits on error like the global flags.
+func newFlagSet(name string) *flag.FlagSet {
+	cmd := commands[name]
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s %s %s\n\n%s\n\nflags:\n",
+			os.Args[0], name, cmd.usage, cmd.help)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// usageError reports a wrong number of arguments.
+func usageError(fs *flag.FlagSet) error {
+	fs.Usage()
+	os.Exit(2)
+	return nil
+}
+
+func openStore() (cert.KeyStore, error) {
+	if *vault == "" {
+		return cert.Dir(*pkiPath), nil
+	}
+
+	password := os.Getenv("CERT_VAULT_PASSWORD")
+	if password == "" {
+		return nil, fmt.Errorf("$CERT_VAULT_PASSWORD is not set")
+	}
+	return cert.OpenVault(*vault, password)
+}
+
+// defaultValidity is the validity of the profiles without validity.
+const defaultValidity = 24 * time.Hour
+
+func loadProfiles() (cert.Profiles, error) {
+	if *profile != "" {
+		profiles, err := cert.LoadProfiles(*profile)
+		if err != nil {
+			return cert.Profiles{}, err
+		}
+		for _, p := range []*cert.Profile{&profiles.CA, &profiles.Server, &profiles.Client} {
+			if p.Validity == 0 {
+				p.Validity = defaultValidity
+			}
+		}
+		return profiles, nil
+	}
+
+	p := cert.Profile{
+		KeyType:      cert.RSA2048,
+		Validity:     defaultValidity,
+		Organization: []string{"washingmachine"},
+		Province:     []string{"france"},
+		Country:      []string{"EU"},
 	}
+	profiles := cert.Profiles{CA: p, Server: p, Client: p}
+	profiles.Server.OCSPServer = []string{"https://localhost:8443/ocsp/"}
+	profiles.Client.OCSPServer = []string{"https://localhost:8443/ocsp/"}
+
+	return profiles, nil
+}
 
-	log.Println("starting server ...")
-	if err := tlsServer.ListenAndServeTLS("", ""); err != nil {
-		log.Fatal(err)
+// clientProfile gives the client certificates of the default profiles
+// their own email address, <name>@washingmachine, unless set with -email.
+func clientProfile(p cert.Profile, name string) cert.Profile {
+	if *profile == "" && len(p.EmailAddresses) == 0 {
+		p.EmailAddresses = []string{name + "@washingmachine"}
 	}
+	return p
+}
+
+// output prints v as JSON with -json, or calls human with a tabwriter.
+func output(v interface{}, human func(w io.Writer)) error {
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	human(tw)
+	return tw.Flush()
 }
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/schorlet/exp/cert"
+)
+
+// listFlag is a flag of comma separated values, which may be repeated.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(s string) error {
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// profileFlags are the subject, SAN and validity options of the
+// commands issuing certificates, they override the loaded profiles.
+type profileFlags struct {
+	validity time.Duration
+	keyType  string
+
+	organization       listFlag
+	organizationalUnit listFlag
+	country            listFlag
+	province           listFlag
+	locality           listFlag
+
+	dnsNames       listFlag
+	ipAddresses    listFlag
+	emailAddresses listFlag
+	uris           listFlag
+}
+
+func newProfileFlags(fs *flag.FlagSet) *profileFlags {
+	f := new(profileFlags)
+	fs.DurationVar(&f.validity, "validity", 0, "validity lifetime, defaults to the profile validity")
+	fs.StringVar(&f.keyType, "key", "", "private key type: rsa2048, rsa4096, p256, p384 or ed25519")
+	fs.Var(&f.organization, "o", "subject organization")
+	fs.Var(&f.organizationalUnit, "ou", "subject organizational unit")
+	fs.Var(&f.country, "c", "subject country")
+	fs.Var(&f.province, "st", "subject state or province")
+	fs.Var(&f.locality, "l", "subject locality")
+	fs.Var(&f.dnsNames, "dns", "DNS names, like *.example.com")
+	fs.Var(&f.ipAddresses, "ip", "IP addresses")
+	fs.Var(&f.emailAddresses, "email", "email addresses")
+	fs.Var(&f.uris, "uri", "URIs, like spiffe://example.com/api")
+	return f
+}
+
+func (f *profileFlags) apply(p cert.Profile) (cert.Profile, error) {
+	if f.validity != 0 {
+		p.Validity = f.validity
+	}
+	if f.keyType != "" {
+		p.KeyType = cert.KeyType(f.keyType)
+	}
+
+	for _, v := range []struct {
+		flag    listFlag
+		profile *[]string
+	}{
+		{f.organization, &p.Organization},
+		{f.organizationalUnit, &p.OrganizationalUnit},
+		{f.country, &p.Country},
+		{f.province, &p.Province},
+		{f.locality, &p.Locality},
+		{f.dnsNames, &p.DNSNames},
+		{f.emailAddresses, &p.EmailAddresses},
+	} {
+		if len(v.flag) != 0 {
+			*v.profile = v.flag
+		}
+	}
+
+	if len(f.ipAddresses) != 0 {
+		p.IPAddresses = nil
+		for _, s := range f.ipAddresses {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return p, fmt.Errorf("invalid ip address: %q", s)
+			}
+			p.IPAddresses = append(p.IPAddresses, ip)
+		}
+	}
+
+	if len(f.uris) != 0 {
+		p.URIs = nil
+		for _, s := range f.uris {
+			uri, err := cert.ParseURI(s)
+			if err != nil {
+				return p, err
+			}
+			p.URIs = append(p.URIs, uri)
+		}
+	}
+
+	return p, nil
+}
+
+// certSummary describes an issued certificate.
+type certSummary struct {
+	Name      string    `json:"name"`
+	Serial    string    `json:"serial"`
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	SANs      []string  `json:"sans,omitempty"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+}
+
+func printCert(name string) error {
+	c, err := cert.ReadCert(store, name)
+	if err != nil {
+		return err
+	}
+
+	s := cert.Inspect(c)
+	summary := certSummary{
+		Name:      name,
+		Serial:    s.Serial,
+		Subject:   s.Subject,
+		Issuer:    s.Issuer,
+		SANs:      s.SANs(),
+		NotBefore: s.NotBefore,
+		NotAfter:  s.NotAfter,
+	}
+
+	return output(summary, func(w io.Writer) {
+		fmt.Fprintf(w, "name:\t%s\n", summary.Name)
+		fmt.Fprintf(w, "serial:\t%s\n", summary.Serial)
+		fmt.Fprintf(w, "subject:\t%s\n", summary.Subject)
+		fmt.Fprintf(w, "issuer:\t%s\n", summary.Issuer)
+		if len(summary.SANs) != 0 {
+			fmt.Fprintf(w, "sans:\t%s\n", strings.Join(summary.SANs, ", "))
+		}
+		fmt.Fprintf(w, "validity:\t%s - %s\n",
+			summary.NotBefore.Format(time.RFC3339), summary.NotAfter.Format(time.RFC3339))
+	})
+}
+
+func initCA(args []string) error {
+	fs := newFlagSet("init-ca")
+	root := fs.String("root", "", "name of the root CA signing an intermediate CA")
+	maxPathLen := fs.Int("max-path-len", 0, "number of intermediate CAs allowed below this CA, negative for unlimited")
+	var permitDNS, excludeDNS, permitIP, excludeIP, permitEmail, excludeEmail listFlag
+	fs.Var(&permitDNS, "permit-dns", "DNS domains the CA may sign, like example.com")
+	fs.Var(&excludeDNS, "exclude-dns", "DNS domains the CA may not sign")
+	fs.Var(&permitIP, "permit-ip", "IP ranges the CA may sign, like 10.0.0.0/8")
+	fs.Var(&excludeIP, "exclude-ip", "IP ranges the CA may not sign")
+	fs.Var(&permitEmail, "permit-email", "email domains or addresses the CA may sign")
+	fs.Var(&excludeEmail, "exclude-email", "email domains or addresses the CA may not sign")
+	pf := newProfileFlags(fs)
+	fs.Parse(args)
+
+	name := "ca"
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		name = fs.Arg(0)
+	default:
+		return usageError(fs)
+	}
+
+	profiles, err := loadProfiles()
+	if err != nil {
+		return fmt.Errorf("load profiles: %v", err)
+	}
+	profile, err := pf.apply(profiles.CA)
+	if err != nil {
+		return err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "max-path-len" {
+			profile.MaxPathLen = *maxPathLen
+		}
+	})
+
+	nc := &profile.NameConstraints
+	for _, v := range []struct {
+		flag    listFlag
+		profile *[]string
+	}{
+		{permitDNS, &nc.PermittedDNSDomains},
+		{excludeDNS, &nc.ExcludedDNSDomains},
+		{permitEmail, &nc.PermittedEmailAddresses},
+		{excludeEmail, &nc.ExcludedEmailAddresses},
+	} {
+		if len(v.flag) != 0 {
+			*v.profile = v.flag
+		}
+	}
+	for _, v := range []struct {
+		flag    listFlag
+		profile *[]*net.IPNet
+	}{
+		{permitIP, &nc.PermittedIPRanges},
+		{excludeIP, &nc.ExcludedIPRanges},
+	} {
+		if len(v.flag) != 0 {
+			*v.profile = nil
+		}
+		for _, s := range v.flag {
+			_, ipNet, err := net.ParseCIDR(s)
+			if err != nil {
+				return fmt.Errorf("invalid ip range: %q", s)
+			}
+			*v.profile = append(*v.profile, ipNet)
+		}
+	}
+
+	if *root != "" {
+		err = cert.CreateIntermediateCACert(store, *root, name, profile)
+	} else {
+		err = cert.CreateCACert(store, name, profile)
+	}
+	if err != nil {
+		return err
+	}
+
+	return printCert(name)
+}
+
+func issue(args []string) error {
+	fs := newFlagSet("issue")
+	ca := fs.String("ca", "ca", "name of the issuing CA")
+	pf := newProfileFlags(fs)
+	if len(args) == 0 {
+		return usageError(fs)
+	}
+	kind := cert.LeafKind(args[0])
+	fs.Parse(args[1:])
+	if fs.NArg() != 1 {
+		return usageError(fs)
+	}
+	name := fs.Arg(0)
+
+	profiles, err := loadProfiles()
+	if err != nil {
+		return fmt.Errorf("load profiles: %v", err)
+	}
+
+	var create func(cert.KeyStore, string, string, cert.Profile) error
+	var profile cert.Profile
+	switch kind {
+	case cert.ServerLeaf:
+		create, profile = cert.CreateServerCert, profiles.Server
+	case cert.ClientLeaf:
+		create, profile = cert.CreateClientCert, profiles.Client
+	default:
+		return usageError(fs)
+	}
+
+	profile, err = pf.apply(profile)
+	if err != nil {
+		return err
+	}
+	if kind == cert.ClientLeaf {
+		profile = clientProfile(profile, name)
+	}
+
+	if err := create(store, *ca, name, profile); err != nil {
+		return err
+	}
+
+	return printCert(name)
+}
+
+// recordJSON is the JSON encoding of an index record.
+type recordJSON struct {
+	Status    string     `json:"status"`
+	Serial    string     `json:"serial"`
+	Name      string     `json:"name"`
+	Subject   string     `json:"subject"`
+	SANs      []string   `json:"sans,omitempty"`
+	Issuer    string     `json:"issuer"`
+	NotAfter  time.Time  `json:"not_after"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+}
+
+func newRecordJSON(r cert.Record, now time.Time) recordJSON {
+	status := r.Status
+	if status == cert.StatusValid && r.Expired(now) {
+		status = cert.StatusExpired
+	}
+
+	v := recordJSON{
+		Status:   string(status),
+		Serial:   fmt.Sprintf("%X", r.Serial),
+		Name:     r.Name,
+		Subject:  r.Subject,
+		SANs:     r.SANs,
+		Issuer:   r.Issuer,
+		NotAfter: r.NotAfter,
+	}
+	if r.Status == cert.StatusRevoked {
+		revokedAt := r.RevokedAt
+		v.RevokedAt = &revokedAt
+		v.Reason = r.Reason.String()
+	}
+	return v
+}
+
+func list(args []string) error {
+	fs := newFlagSet("list")
+	ca := fs.String("ca", "", "list only the certificates issued by this CA")
+	status := fs.String("status", "", "list only the certificates of this status: V (valid), R (revoked) or E (expired)")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		return usageError(fs)
+	}
+
+	now := time.Now()
+	records, err := cert.FindRecords(store, func(r cert.Record) bool {
+		return *ca == "" || r.Issuer == *ca
+	})
+	if err != nil {
+		return err
+	}
+
+	values := []recordJSON{}
+	for _, record := range records {
+		v := newRecordJSON(record, now)
+		if *status == "" || v.Status == *status {
+			values = append(values, v)
+		}
+	}
+
+	return output(values, func(w io.Writer) {
+		fmt.Fprintf(w, "STATUS\tSERIAL\tNAME\tISSUER\tNOT AFTER\tSANS\n")
+		for _, v := range values {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", v.Status, v.Serial, v.Name, v.Issuer,
+				v.NotAfter.Format(time.RFC3339), strings.Join(v.SANs, ","))
+		}
+	})
+}
+
+func revoke(args []string) error {
+	fs := newFlagSet("revoke")
+	ca := fs.String("ca", "ca", "name of the issuing CA")
+	reasonName := fs.String("reason", cert.ReasonUnspecified.String(), "revocation reason, such as keyCompromise or superseded")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return usageError(fs)
+	}
+	arg := fs.Arg(0)
+
+	reason, err := cert.ParseRevocationReason(*reasonName)
+	if err != nil {
+		return err
+	}
+
+	// a name revokes all its valid certificates
+	records, err := cert.FindRecords(store, func(r cert.Record) bool {
+		return r.Issuer == *ca && r.Name == arg && r.Status == cert.StatusValid
+	})
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		serial, ok := new(big.Int).SetString(arg, 16)
+		if !ok {
+			return fmt.Errorf("no valid certificate named %q", arg)
+		}
+		record, err := cert.FindRecord(store, serial)
+		if err != nil {
+			return fmt.Errorf("find %X: %v", serial, err)
+		}
+		records = append(records, record)
+	}
+
+	now := time.Now()
+	values := []recordJSON{}
+	for _, record := range records {
+		if err := cert.Revoke(store, *ca, record.Serial, reason); err != nil {
+			return fmt.Errorf("revoke %X: %v", record.Serial, err)
+		}
+		record.Status = cert.StatusRevoked
+		record.RevokedAt = now
+		record.Reason = reason
+		values = append(values, newRecordJSON(record, now))
+	}
+
+	return output(values, func(w io.Writer) {
+		for _, v := range values {
+			fmt.Fprintf(w, "revoked %s\t%s\t(%s)\n", v.Serial, v.Name, v.Reason)
+		}
+		fmt.Fprintf(w, "run %s crl to publish the revocation\n", os.Args[0])
+	})
+}
+
+func renew(args []string) error {
+	fs := newFlagSet("renew")
+	ca := fs.String("ca", "", "name of the issuing CA, the issuer of the index by default")
+	pf := newProfileFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return usageError(fs)
+	}
+	name := fs.Arg(0)
+
+	old, err := cert.ReadCert(store, name)
+	if err != nil {
+		return err
+	}
+
+	profiles, err := loadProfiles()
+	if err != nil {
+		return fmt.Errorf("load profiles: %v", err)
+	}
+
+	// the kind, the issuer and the SANs of the current certificate are kept
+	kind, err := cert.LeafKindOf(old)
+	if err != nil {
+		return fmt.Errorf("cannot renew %q: %v", name, err)
+	}
+	renewCert, profile := cert.RenewClientCert, profiles.Client
+	if kind == cert.ServerLeaf {
+		renewCert, profile = cert.RenewServerCert, profiles.Server
+	}
+
+	if *ca == "" {
+		record, err := cert.FindRecord(store, old.SerialNumber)
+		if err != nil {
+			return fmt.Errorf("find %q record: %v", name, err)
+		}
+		*ca = record.Issuer
+	}
+
+	profile.DNSNames = old.DNSNames
+	profile.IPAddresses = old.IPAddresses
+	profile.EmailAddresses = old.EmailAddresses
+	profile.URIs = old.URIs
+
+	profile, err = pf.apply(profile)
+	if err != nil {
+		return err
+	}
+
+	if err := renewCert(store, *ca, name, profile); err != nil {
+		return err
+	}
+
+	return printCert(name)
+}
+
+func crl(args []string) error {
+	fs := newFlagSet("crl")
+	ca := fs.String("ca", "ca", "name of the CA")
+	validity := fs.Duration("validity", defaultValidity, "validity of the CRL")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		return usageError(fs)
+	}
+
+	if err := cert.GenerateCRL(store, *ca, *validity); err != nil {
+		return err
+	}
+
+	list, err := cert.ReadCRL(store, *ca)
+	if err != nil {
+		return err
+	}
+
+	summary := struct {
+		Issuer     string    `json:"issuer"`
+		Number     string    `json:"number"`
+		ThisUpdate time.Time `json:"this_update"`
+		NextUpdate time.Time `json:"next_update"`
+		Revoked    []string  `json:"revoked"`
+	}{
+		Issuer:     list.Issuer.String(),
+		Number:     list.Number.String(),
+		ThisUpdate: list.ThisUpdate,
+		NextUpdate: list.NextUpdate,
+		Revoked:    []string{},
+	}
+	for _, entry := range list.RevokedCertificateEntries {
+		summary.Revoked = append(summary.Revoked, fmt.Sprintf("%X", entry.SerialNumber))
+	}
+
+	return output(summary, func(w io.Writer) {
+		fmt.Fprintf(w, "issuer:\t%s\n", summary.Issuer)
+		fmt.Fprintf(w, "number:\t%s\n", summary.Number)
+		fmt.Fprintf(w, "this update:\t%s\n", summary.ThisUpdate.Format(time.RFC3339))
+		fmt.Fprintf(w, "next update:\t%s\n", summary.NextUpdate.Format(time.RFC3339))
+		fmt.Fprintf(w, "revoked:\t%s\n", strings.Join(summary.Revoked, ", "))
+	})
+}
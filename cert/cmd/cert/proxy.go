@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/schorlet/exp/cert"
+)
+
+func proxy(args []string) error {
+	fs := newFlagSet("proxy")
+	addr := fs.String("addr", ":8443", "address to listen on")
+	ca := fs.String("ca", "ca", "name of the CA of the clients")
+	server := fs.String("server", "localhost", "name of the server certificate")
+	preset := fs.String("tls", string(cert.PresetIntermediate), "TLS preset: modern, intermediate or legacy")
+	clientAuth := fs.String("client-auth", string(cert.ClientAuthRequired), "client authentication: none, optional or required")
+	withCRL := fs.Bool("crl", true, "reject the client certificates revoked in the CRL written by the crl command")
+	idle := fs.Duration("stream-idle", 30*time.Second, "idle timeout of the long or streamed responses of the upstream")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return usageError(fs)
+	}
+
+	upstream, err := url.Parse(fs.Arg(0))
+	if err != nil || upstream.Scheme == "" || upstream.Host == "" {
+		return fmt.Errorf("invalid upstream: %q", fs.Arg(0))
+	}
+
+	// the server certificate renewed by serve or renew is reloaded
+	reloader, err := cert.NewCertReloader(store, *ca, *server, 10*time.Second)
+	if err != nil {
+		return fmt.Errorf("create cert reloader: %v", err)
+	}
+
+	opts := []cert.TLSOption{
+		cert.WithReloader(reloader),
+		cert.WithPreset(cert.TLSPreset(*preset)), cert.WithClientAuth(cert.ClientAuthMode(*clientAuth)),
+	}
+	if *withCRL {
+		opts = append(opts, cert.WithCRL())
+	}
+
+	// the write timeout of the server would cut the long responses
+	handler := cert.StreamingHandler(cert.IdentityProxy(upstream), *idle)
+	tlsServer, err := cert.NewTLSServer(store, *ca, *server, *addr, handler, opts...)
+	if err != nil {
+		return fmt.Errorf("create tls server: %v", err)
+	}
+
+	log.Printf("proxying %s to %s ...\n", *addr, upstream)
+	return tlsServer.ListenAndServeTLS("", "")
+}
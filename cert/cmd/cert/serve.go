@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/schorlet/exp/cert"
+)
+
+func serve(args []string) error {
+	fs := newFlagSet("serve")
+	addr := fs.String("addr", ":8443", "address to listen on")
+	ca := fs.String("ca", "ca", "name of the CA")
+	server := fs.String("server", "localhost", "name of the server certificate")
+	client := fs.String("client", "client", "name of the client certificate created with a new CA")
+	var domains listFlag
+	fs.Var(&domains, "ca-domains", "DNS suffixes allowed in certificate requests, localhost and the washingmachine domain of the client emails by default")
+	var requesters listFlag
+	fs.Var(&requesters, "ca-server-requesters", "common names, email addresses or URIs of the clients allowed to request server certificates, the -client by default")
+	renewAt := fs.Float64("renew", cert.DefaultRenewThreshold, "fraction of the validity after which certificates are renewed")
+	preset := fs.String("tls", string(cert.PresetIntermediate), "TLS preset: modern, intermediate or legacy")
+	clientAuth := fs.String("client-auth", string(cert.ClientAuthOptional), "client authentication: none, optional or required")
+	resumption := fs.Bool("session-resumption", false, "enable TLS session tickets")
+	idle := fs.Duration("stream-idle", 30*time.Second, "idle timeout of the streaming routes")
+	pf := newProfileFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		return usageError(fs)
+	}
+
+	profiles, err := loadProfiles()
+	if err != nil {
+		return fmt.Errorf("load profiles: %v", err)
+	}
+	for _, p := range []*cert.Profile{&profiles.CA, &profiles.Server, &profiles.Client} {
+		if *p, err = pf.apply(*p); err != nil {
+			return err
+		}
+	}
+
+	// a new PKI is created on the first run
+	if _, err := cert.ReadCert(store, *ca); os.IsNotExist(err) {
+		created := profiles
+		created.Client = clientProfile(created.Client, *client)
+		if err := cert.CreateCerts(store, *ca, *server, *client, created); err != nil {
+			return fmt.Errorf("create certs: %v", err)
+		}
+	} else if err != nil {
+		return err
+	}
+
+	if err := cert.GenerateCRL(store, *ca, profiles.CA.Validity); err != nil {
+		return fmt.Errorf("generate crl: %v", err)
+	}
+
+	validity := profiles.Server.Validity
+	if profiles.Client.Validity > validity {
+		validity = profiles.Client.Validity
+	}
+	if len(domains) == 0 {
+		domains = listFlag{"localhost", "washingmachine"}
+	}
+	if len(requesters) == 0 {
+		requesters = listFlag{*client}
+	}
+	policy := cert.Policy{
+		DNSSuffixes:      domains,
+		MaxValidity:      validity,
+		ServerRequesters: requesters,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", cert.HelloHandler("world"))
+	// the streaming routes outlive the timeouts of the server while active
+	mux.Handle("/echo", cert.StreamingHandler(http.HandlerFunc(cert.EchoHandler), *idle))
+	mux.Handle("/stream", cert.StreamingHandler(
+		cert.StreamHandler(cert.EchoStream, cert.WithKeepAlive(5*time.Second, 15*time.Second)), *idle))
+	mux.Handle("/ca/", http.StripPrefix("/ca", cert.CAHandler(store, *ca, profiles, policy)))
+	mux.Handle("/ocsp/", http.StripPrefix("/ocsp", cert.OCSPHandler(store, *ca, time.Hour)))
+
+	renewer := cert.NewRenewer(store, *ca, []cert.Leaf{
+		{Kind: cert.ServerLeaf, Name: *server, Profile: profiles.Server},
+	}, *renewAt)
+	if err := renewer.Check(); err != nil {
+		return err
+	}
+
+	reloader, err := cert.NewCertReloader(store, *ca, *server, 10*time.Second)
+	if err != nil {
+		return fmt.Errorf("create cert reloader: %v", err)
+	}
+
+	renewer.Subscribe(func(cert.Leaf, *x509.Certificate) {
+		if err := reloader.Reload(); err != nil {
+			log.Printf("reload server cert: %v", err)
+		}
+	})
+	go renewer.Run(context.Background(), time.Minute)
+
+	opts := []cert.TLSOption{
+		cert.WithCRL(), cert.WithReloader(reloader), cert.WithOCSPStapling(),
+		cert.WithPreset(cert.TLSPreset(*preset)), cert.WithClientAuth(cert.ClientAuthMode(*clientAuth)),
+	}
+	if *resumption {
+		opts = append(opts, cert.WithSessionResumption(0))
+	}
+
+	tlsServer, err := cert.NewTLSServer(store, *ca, *server, *addr, mux, opts...)
+	if err != nil {
+		return fmt.Errorf("create tls server: %v", err)
+	}
+
+	log.Printf("starting server on %s ...\n", *addr)
+	return tlsServer.ListenAndServeTLS("", "")
+}
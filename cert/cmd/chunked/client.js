@@ -56,3 +56,24 @@ race(fetch('/'), 2000)
 	return pump();
 })
 .catch(err => console.error(err));
+
+// -------------------
+
+// https://developer.mozilla.org/en-US/docs/Web/API/EventSource
+// reconnects with the Last-Event-ID header, the server replays the missed events
+
+var source = new EventSource('/events');
+source.addEventListener('time', e => console.log(e.lastEventId, e.data));
+source.onerror = err => console.error(err);
+
+// -------------------
+
+// https://developer.mozilla.org/en-US/docs/Web/API/WebSocket
+// the pings of the server are answered by the browser
+
+var socket = new WebSocket('ws://' + location.host + '/ws');
+socket.onmessage = e => {
+	const m = JSON.parse(e.data);
+	console.log(m.id, m.data, m.dropped ? '(' + m.dropped + ' dropped)' : '');
+};
+socket.onclose = e => console.log('closed', e.code, e.reason);
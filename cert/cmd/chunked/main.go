@@ -9,23 +9,43 @@ import (
 	"net/http"
 	"net/http/pprof"
 	"time"
+
+	"github.com/schorlet/exp/cert"
+	"github.com/schorlet/exp/cert/sse"
 )
 
-var cli = flag.Bool("cli", false, "client")
+var (
+	cli       = flag.Bool("cli", false, "client")
+	transport = flag.String("transport", "chunked", "client transport: chunked, sse or ws")
+	delay     = flag.Duration("delay", 0, "client delay after each event, to simulate a slow consumer")
+
+	interval = flag.Duration("interval", 1*time.Second, "server interval between the events")
+	wsPolicy = flag.String("ws-policy", policyDrop, "server policy for the slow websocket clients: drop or disconnect")
+	wsQueue  = flag.Int("ws-queue", 16, "server queue size of each websocket client")
+)
 
 func main() {
 	flag.Parse()
 	log.SetFlags(0)
 
 	if *cli {
-		log.Fatalf("client: %v", client())
+		switch *transport {
+		case "chunked":
+			log.Fatalf("client: %v", client())
+		case "sse":
+			log.Fatalf("client: %v", sseClient())
+		case "ws":
+			log.Fatalf("client: %v", wsClient(*delay))
+		default:
+			log.Fatalf("client: unknown transport: %q", *transport)
+		}
 	}
 	log.Fatalf("server: %v", serve())
 }
 
 func client() error {
-	ctx := context.Background()
-	ctx, _ = context.WithTimeout(ctx, 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
 	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8000/", nil)
 	req = req.WithContext(ctx)
@@ -42,12 +62,41 @@ func client() error {
 			return err
 		}
 		log.Print(string(line))
+		time.Sleep(*delay)
 	}
 }
 
+// sseClient reconnects when the server restarts, and resumes after the
+// last received event.
+func sseClient() error {
+	client := sse.Client{URL: "http://localhost:8000/events"}
+	return client.Subscribe(context.Background(), func(e sse.Event) error {
+		log.Printf("%s %s: %s", e.ID, e.Event, e.Data)
+		time.Sleep(*delay)
+		return nil
+	})
+}
+
 func serve() error {
+	switch *wsPolicy {
+	case policyDrop, policyDisconnect:
+	default:
+		return fmt.Errorf("unknown websocket policy: %q", *wsPolicy)
+	}
+
+	// all the subscribers share the same producer, whatever the transport
+	hub := sse.NewHub()
+	go func() {
+		for now := range time.Tick(*interval) {
+			hub.Publish("time", fmt.Sprintf("It is now %s", now.UTC()))
+		}
+	}()
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", chunked)
+	mux.Handle("/", chunked(hub))
+	mux.Handle("/events", cert.StreamingHandler(hub, 30*time.Second))
+	// the hijacked connections are not limited by the server timeouts
+	mux.Handle("/ws", &wsHandler{hub: hub, policy: *wsPolicy, queue: *wsQueue})
 	mux.HandleFunc("/worker.html", handleFile("worker.html"))
 	mux.HandleFunc("/worker.js", handleFile("worker.js"))
 
@@ -72,24 +121,43 @@ func handleFile(name string) http.HandlerFunc {
 	}
 }
 
-func chunked(w http.ResponseWriter, r *http.Request) {
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		panic("not a http.Flusher")
-	}
-
-	w.Header().Set("Transfer-Encoding", "chunked")
-	w.Header().Set("X-Content-Type-Options", "nosniff")
-
-	for {
-		select {
-		case <-time.After(1 * time.Second):
-			fmt.Fprintf(w, "It is now %s\n", time.Now().UTC())
-			flusher.Flush()
+// chunked streams the data of the events of the hub, one per line. The
+// stream cannot be resumed: a client lagging behind the buffer of the hub
+// is told so, then disconnected.
+func chunked(hub *sse.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			panic("not a http.Flusher")
+		}
 
-		case <-r.Context().Done():
-			log.Printf("server: %v", r.Context().Err())
+		sub, _, err := hub.Subscribe("")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
 			return
 		}
+		defer sub.Close()
+
+		w.Header().Set("Transfer-Encoding", "chunked")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+
+		for {
+			select {
+			case e, ok := <-sub.Events():
+				if !ok {
+					if err := sub.Err(); err != nil {
+						log.Printf("chunked %s: %v", r.RemoteAddr, err)
+						fmt.Fprintf(w, "disconnected: %v\n", err)
+					}
+					return
+				}
+				fmt.Fprintf(w, "%s\n", e.Data)
+				flusher.Flush()
+
+			case <-r.Context().Done():
+				log.Printf("server: %v", r.Context().Err())
+				return
+			}
+		}
 	}
 }
@@ -0,0 +1,211 @@
+package main
+
+// https://github.com/gorilla/websocket/tree/main/examples/chat
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/schorlet/exp/cert/sse"
+)
+
+const (
+	wsWriteWait  = 10 * time.Second
+	wsPongWait   = 30 * time.Second
+	wsPingPeriod = 10 * time.Second
+)
+
+// The policies for the clients which cannot keep up with the events.
+const (
+	// policyDrop skips the events, the next message counts them.
+	policyDrop = "drop"
+	// policyDisconnect closes the connection, the client may resume
+	// from the buffer of the hub.
+	policyDisconnect = "disconnect"
+)
+
+// wsMessage is the JSON message of an event.
+type wsMessage struct {
+	ID    string `json:"id"`
+	Event string `json:"event"`
+	Data  string `json:"data"`
+	// Dropped counts the events dropped before this one.
+	Dropped int `json:"dropped,omitempty"`
+}
+
+func newMessage(e sse.Event) wsMessage {
+	return wsMessage{ID: e.ID, Event: e.Event, Data: e.Data}
+}
+
+// wsHandler sends the events of the hub to the websocket clients. The
+// clients resume after the last_event_id query parameter, as the browsers
+// cannot set the Last-Event-ID header.
+type wsHandler struct {
+	hub    *sse.Hub
+	policy string
+	// queue is the number of events waiting for a client
+	queue int
+	// pingPeriod and pongWait default to wsPingPeriod and wsPongWait
+	pingPeriod time.Duration
+	pongWait   time.Duration
+
+	upgrader websocket.Upgrader
+}
+
+func (h *wsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pingPeriod, pongWait := wsPingPeriod, wsPongWait
+	if h.pingPeriod > 0 {
+		pingPeriod, pongWait = h.pingPeriod, h.pongWait
+	}
+
+	sub, replay, err := h.hub.Subscribe(r.URL.Query().Get("last_event_id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer sub.Close()
+
+	// the errors are answered by Upgrade
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// the read loop handles the pongs and the close frame of the client
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+
+		conn.SetReadLimit(512)
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(pongWait))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, e := range replay {
+		conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		if err := conn.WriteJSON(newMessage(e)); err != nil {
+			return
+		}
+	}
+
+	// the events are moved to the queue as soon as published, the hub
+	// only disconnects the clients lagging behind its buffer during bursts
+	queue := make(chan wsMessage, h.queue)
+	var overflow bool
+	go func() {
+		defer close(queue)
+
+		var dropped int
+		for e := range sub.Events() {
+			m := newMessage(e)
+			m.Dropped = dropped
+
+			select {
+			case queue <- m:
+				dropped = 0
+			default:
+				if h.policy == policyDisconnect {
+					log.Printf("websocket %s: slow consumer", r.RemoteAddr)
+					overflow = true
+					return
+				}
+				dropped++
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case m, ok := <-queue:
+			if !ok {
+				// overflow is set before closing the queue
+				code, reason := websocket.CloseTryAgainLater, "slow consumer"
+				if !overflow && sub.Err() == sse.ErrClosed {
+					code, reason = websocket.CloseGoingAway, "hub closed"
+				}
+				msg := websocket.FormatCloseMessage(code, reason)
+				conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteWait))
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteJSON(m); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+
+		case <-closed:
+			return
+		}
+	}
+}
+
+// wsClient reconnects after the errors, and resumes after the last
+// received event.
+func wsClient(delay time.Duration) error {
+	var lastID string
+	for {
+		err := wsRead(context.Background(), &lastID, delay)
+		if ce, ok := err.(*websocket.CloseError); ok && ce.Code == websocket.CloseGoingAway {
+			return err
+		}
+		log.Printf("client: %v, reconnecting", err)
+		time.Sleep(3 * time.Second)
+	}
+}
+
+func wsRead(ctx context.Context, lastID *string, delay time.Duration) error {
+	u := url.URL{Scheme: "ws", Host: "localhost:8000", Path: "/ws"}
+	if *lastID != "" {
+		u.RawQuery = url.Values{"last_event_id": {*lastID}}.Encode()
+	}
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// the server pings every wsPingPeriod, the pings are answered
+	// while reading and extend the read deadline
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(wsWriteWait))
+	})
+
+	for {
+		var m wsMessage
+		if err := conn.ReadJSON(&m); err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+
+		*lastID = m.ID
+		dropped := ""
+		if m.Dropped > 0 {
+			dropped = fmt.Sprintf(" (%d dropped)", m.Dropped)
+		}
+		log.Printf("%s %s: %s%s", m.ID, m.Event, m.Data, dropped)
+		time.Sleep(delay)
+	}
+}
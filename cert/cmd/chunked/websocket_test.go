@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/schorlet/exp/cert/sse"
+)
+
+// smallBuffers lets the slow clients block the writes of the server.
+type smallBuffers struct {
+	net.Listener
+}
+
+func (l smallBuffers) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		conn.(*net.TCPConn).SetWriteBuffer(4096)
+	}
+	return conn, err
+}
+
+func newWSServer(h *wsHandler) *httptest.Server {
+	server := httptest.NewUnstartedServer(h)
+	server.Listener = smallBuffers{server.Listener}
+	server.Start()
+	return server
+}
+
+// dialWS connects a client reading through a small buffer.
+func dialWS(t *testing.T, server *httptest.Server, lastID string) *websocket.Conn {
+	t.Helper()
+
+	dialer := websocket.Dialer{
+		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := new(net.Dialer).DialContext(ctx, network, addr)
+			if err == nil {
+				conn.(*net.TCPConn).SetReadBuffer(4096)
+			}
+			return conn, err
+		},
+	}
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	if lastID != "" {
+		url += "?last_event_id=" + lastID
+	}
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func waitSubscribers(t *testing.T, hub *sse.Hub, n int) {
+	t.Helper()
+	for i := 0; hub.Subscribers() != n; i++ {
+		if i == 200 {
+			t.Fatalf("subscribers: %d, want %d", hub.Subscribers(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// publish sends a burst of large events, faster than the slow clients read.
+func publish(hub *sse.Hub, n int) {
+	data := strings.Repeat("x", 16<<10)
+	for i := 0; i < n; i++ {
+		hub.Publish("tick", data)
+	}
+}
+
+// readSlowly reads the messages until the event last, or the first error.
+func readSlowly(conn *websocket.Conn, last string) ([]wsMessage, error) {
+	var msgs []wsMessage
+	for {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var m wsMessage
+		if err := conn.ReadJSON(&m); err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, m)
+		if m.ID == last {
+			return msgs, nil
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func closeCode(err error) int {
+	if ce, ok := err.(*websocket.CloseError); ok {
+		return ce.Code
+	}
+	return -1
+}
+
+func TestWebsocketDrop(t *testing.T) {
+	hub := sse.NewHub()
+	server := newWSServer(&wsHandler{hub: hub, policy: policyDrop, queue: 2})
+	defer server.Close()
+
+	conn := dialWS(t, server, "")
+	defer conn.Close()
+	waitSubscribers(t, hub, 1)
+
+	publish(hub, 40)
+	// the last message counts the events dropped at the end of the burst
+	time.AfterFunc(500*time.Millisecond, func() {
+		hub.Publish("tick", "end")
+	})
+	msgs, err := readSlowly(conn, "41")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the dropped events are counted by the next message
+	var prev, dropped int
+	for _, m := range msgs {
+		id, _ := strconv.Atoi(m.ID)
+		if id != prev+m.Dropped+1 {
+			t.Fatalf("message %s after %d with %d dropped", m.ID, prev, m.Dropped)
+		}
+		prev = id
+		dropped += m.Dropped
+	}
+	if dropped == 0 {
+		t.Fatal("no dropped event")
+	}
+	if len(msgs)+dropped != 41 {
+		t.Errorf("%d messages and %d dropped", len(msgs), dropped)
+	}
+
+	hub.Close()
+	if _, err := readSlowly(conn, ""); closeCode(err) != websocket.CloseGoingAway {
+		t.Errorf("after hub close: %v", err)
+	}
+}
+
+func TestWebsocketDisconnect(t *testing.T) {
+	hub := sse.NewHub()
+	server := newWSServer(&wsHandler{hub: hub, policy: policyDisconnect, queue: 2})
+	defer server.Close()
+
+	conn := dialWS(t, server, "")
+	defer conn.Close()
+	waitSubscribers(t, hub, 1)
+
+	publish(hub, 40)
+	msgs, err := readSlowly(conn, "40")
+	if ce, ok := err.(*websocket.CloseError); !ok || ce.Code != websocket.CloseTryAgainLater || ce.Text != "slow consumer" {
+		t.Fatalf("slow consumer not disconnected: %v", err)
+	}
+	if len(msgs) == 0 || len(msgs) == 40 {
+		t.Fatalf("%d messages before the disconnection", len(msgs))
+	}
+	for i, m := range msgs {
+		if m.ID != strconv.Itoa(i+1) || m.Dropped != 0 {
+			t.Fatalf("unexpected message %d: %s, %d dropped", i, m.ID, m.Dropped)
+		}
+	}
+
+	// the client resumes from the buffer of the hub
+	last := msgs[len(msgs)-1].ID
+	resumed := dialWS(t, server, last)
+	defer resumed.Close()
+
+	var m wsMessage
+	resumed.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := resumed.ReadJSON(&m); err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := strconv.Atoi(last); m.ID != strconv.Itoa(id+1) {
+		t.Errorf("resumed with %s after %s", m.ID, last)
+	}
+}
+
+func TestWebsocketPing(t *testing.T) {
+	hub := sse.NewHub()
+	server := newWSServer(&wsHandler{
+		hub: hub, policy: policyDrop, queue: 2,
+		pingPeriod: 20 * time.Millisecond, pongWait: 100 * time.Millisecond,
+	})
+	defer server.Close()
+
+	// the pongs of the reading clients extend the deadline
+	conn := dialWS(t, server, "")
+	defer conn.Close()
+
+	pings := make(chan struct{}, 100)
+	conn.SetPingHandler(func(data string) error {
+		pings <- struct{}{}
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	waitSubscribers(t, hub, 1)
+
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		hub.Publish("tick", "late")
+	}()
+	var m wsMessage
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&m); err != nil {
+		t.Fatalf("disconnected while answering the pings: %v", err)
+	}
+	if m.Data != "late" || len(pings) < 5 {
+		t.Errorf("message %q after %d pings", m.Data, len(pings))
+	}
+
+	conn.Close()
+	waitSubscribers(t, hub, 0)
+
+	// the silent clients are disconnected after pongWait
+	silent := dialWS(t, server, "")
+	defer silent.Close()
+	waitSubscribers(t, hub, 1)
+	waitSubscribers(t, hub, 0)
+}
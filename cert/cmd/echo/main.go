@@ -3,6 +3,7 @@ package main
 // https://posener.github.io/http2/#full-duplex-communication
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"flag"
@@ -14,18 +15,26 @@ import (
 	"os"
 	"time"
 
+	"github.com/schorlet/exp/cert"
 	"golang.org/x/net/http2"
 )
 
 var (
 	url         = "https://localhost:8443/echo"
+	streamURL   = "https://localhost:8443/stream"
 	httpVersion = flag.Int("version", 2, "HTTP version")
+	stream      = flag.Bool("stream", false, "exchange framed messages with the stream handler")
 )
 
 func main() {
 	flag.Parse()
 	log.SetFlags(0)
 
+	if *stream {
+		runStream()
+		return
+	}
+
 	// Create a pipe - an object that implements `io.Reader` and `io.Writer`.
 	// Whatever is written to the writer part will be read by the reader part.
 	pr, pw := io.Pipe()
@@ -40,28 +49,18 @@ func main() {
 		log.Fatalf("create http request: %v", err)
 	}
 
-	// Create TLS config
-	data, err := ioutil.ReadFile("/tmp/ca.crt")
-	if err != nil {
-		log.Fatalf("read ca cert: %v", err)
-	}
-	pool := x509.NewCertPool()
-	pool.AppendCertsFromPEM(data)
-
-	tlsConfig := tls.Config{
-		RootCAs: pool,
-	}
+	tlsConfig := newTLSConfig()
 
 	// Use the proper transport in the client
 	var client http.Client
 	switch *httpVersion {
 	case 1:
 		client.Transport = &http.Transport{
-			TLSClientConfig: &tlsConfig,
+			TLSClientConfig: tlsConfig,
 		}
 	case 2:
 		client.Transport = &http2.Transport{
-			TLSClientConfig: &tlsConfig,
+			TLSClientConfig: tlsConfig,
 		}
 	}
 
@@ -82,7 +81,55 @@ func main() {
 	}()
 
 	// Copy the server's response to stdout.
-	// Will fail on server WriteTimeout.
+	// Will fail when the stream is idle for the timeout of the server.
 	_, err = io.Copy(os.Stdout, resp.Body)
 	log.Fatalf("reading from response body: %v", err)
 }
+
+func newTLSConfig() *tls.Config {
+	data, err := ioutil.ReadFile("/tmp/ca.crt")
+	if err != nil {
+		log.Fatalf("read ca cert: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(data)
+
+	return &tls.Config{
+		RootCAs: pool,
+	}
+}
+
+// runStream does the same with cert.DialStream, which wraps the pipe
+// and frames the messages.
+func runStream() {
+	client := &http.Client{
+		Transport: &http2.Transport{
+			TLSClientConfig: newTLSConfig(),
+		},
+	}
+
+	s, err := cert.DialStream(context.Background(), client, streamURL,
+		cert.WithKeepAlive(5*time.Second, 15*time.Second))
+	if err != nil {
+		log.Fatalf("dial stream: %v", err)
+	}
+	defer s.Close()
+
+	go func() {
+		for {
+			time.Sleep(1 * time.Second)
+			msg := fmt.Sprintf("It is now %v", time.Now().UTC())
+			if err := s.Send([]byte(msg)); err != nil {
+				log.Fatalf("send: %v", err)
+			}
+		}
+	}()
+
+	for {
+		msg, err := s.Recv()
+		if err != nil {
+			log.Fatalf("receive: %v", err)
+		}
+		fmt.Printf("%s\n", msg)
+	}
+}
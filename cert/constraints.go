@@ -0,0 +1,174 @@
+package cert
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// NameConstraints restricts the names of the certificates signed by a CA,
+// and by the CAs it signs.
+//
+// A DNS domain like example.com permits example.com and its subdomains,
+// a domain with a leading dot like .example.com only permits subdomains.
+// An email constraint is a mailbox, like bob@example.com, or a domain.
+// URI domains restrict the host of the URIs, like the trust domain of
+// spiffe:// identities. A non-empty permitted list rejects the names of
+// its kind matching none of its entries, an excluded name is always
+// rejected.
+type NameConstraints struct {
+	// Critical marks the extension as critical, as required by RFC 5280,
+	// which may be unsupported by some clients.
+	Critical bool
+
+	PermittedDNSDomains []string
+	ExcludedDNSDomains  []string
+
+	PermittedIPRanges []*net.IPNet
+	ExcludedIPRanges  []*net.IPNet
+
+	PermittedEmailAddresses []string
+	ExcludedEmailAddresses  []string
+
+	PermittedURIDomains []string
+	ExcludedURIDomains  []string
+}
+
+// set sets the name constraints extension of the CA certificate.
+func (c NameConstraints) set(cert *x509.Certificate) {
+	cert.PermittedDNSDomainsCritical = c.Critical
+	cert.PermittedDNSDomains = c.PermittedDNSDomains
+	cert.ExcludedDNSDomains = c.ExcludedDNSDomains
+	cert.PermittedIPRanges = c.PermittedIPRanges
+	cert.ExcludedIPRanges = c.ExcludedIPRanges
+	cert.PermittedEmailAddresses = c.PermittedEmailAddresses
+	cert.ExcludedEmailAddresses = c.ExcludedEmailAddresses
+	cert.PermittedURIDomains = c.PermittedURIDomains
+	cert.ExcludedURIDomains = c.ExcludedURIDomains
+}
+
+// checkIssuerConstraints checks the names of the certificate against the
+// name constraints of the CA and of its issuers, found in the index of
+// store. A violation returns a *PolicyError.
+func checkIssuerConstraints(store KeyStore, ca string, cert *x509.Certificate) error {
+	issuer, err := ReadCert(store, ca)
+	if err != nil {
+		return fmt.Errorf("read ca cert: %v", err)
+	}
+
+	// the depth is bounded in case of a loop in the index
+	for depth := 0; depth < 8; depth++ {
+		if err := checkNameConstraints(issuer, cert); err != nil {
+			return err
+		}
+
+		record, err := FindRecord(store, issuer.SerialNumber)
+		if err != nil && err != ErrNotFound {
+			return fmt.Errorf("find ca record: %v", err)
+		}
+		if err == ErrNotFound || record.Issuer == record.Name {
+			return nil
+		}
+
+		if issuer, err = ReadCert(store, record.Issuer); err != nil {
+			return fmt.Errorf("read ca cert: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// checkNameConstraints checks the names of the certificate against the
+// name constraints of the CA, as x509 verification does. A violation
+// returns a *PolicyError.
+func checkNameConstraints(ca, cert *x509.Certificate) error {
+	check := func(kind, name string, permitted, excluded []string, match func(name, constraint string) bool) error {
+		for _, constraint := range excluded {
+			if match(name, constraint) {
+				return policyErrorf("%s %q excluded by the name constraints of %s", kind, name, ca.Subject)
+			}
+		}
+		if len(permitted) == 0 {
+			return nil
+		}
+		for _, constraint := range permitted {
+			if match(name, constraint) {
+				return nil
+			}
+		}
+		return policyErrorf("%s %q not permitted by the name constraints of %s", kind, name, ca.Subject)
+	}
+
+	for _, name := range cert.DNSNames {
+		err := check("dns name", name, ca.PermittedDNSDomains, ca.ExcludedDNSDomains, matchDomain)
+		if err != nil {
+			return err
+		}
+	}
+	for _, email := range cert.EmailAddresses {
+		err := check("email address", email, ca.PermittedEmailAddresses, ca.ExcludedEmailAddresses, matchEmail)
+		if err != nil {
+			return err
+		}
+	}
+	for _, uri := range cert.URIs {
+		err := check("uri", uri.String(), ca.PermittedURIDomains, ca.ExcludedURIDomains,
+			func(_, constraint string) bool { return matchDomain(uri.Hostname(), constraint) })
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, ip := range cert.IPAddresses {
+		for _, ipNet := range ca.ExcludedIPRanges {
+			if ipNet.Contains(ip) {
+				return policyErrorf("ip address %v excluded by the name constraints of %s", ip, ca.Subject)
+			}
+		}
+		permitted := len(ca.PermittedIPRanges) == 0
+		for _, ipNet := range ca.PermittedIPRanges {
+			permitted = permitted || ipNet.Contains(ip)
+		}
+		if !permitted {
+			return policyErrorf("ip address %v not permitted by the name constraints of %s", ip, ca.Subject)
+		}
+	}
+
+	return nil
+}
+
+// matchDomain reports whether the domain name matches the constraint:
+// the constraint itself or a subdomain, only a subdomain with a leading dot.
+func matchDomain(name, constraint string) bool {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	constraint = strings.ToLower(constraint)
+
+	if constraint == "" {
+		return true
+	}
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(name, constraint)
+	}
+	return name == constraint || strings.HasSuffix(name, "."+constraint)
+}
+
+func matchEmail(email, constraint string) bool {
+	if strings.Contains(constraint, "@") {
+		return strings.EqualFold(email, constraint)
+	}
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return false
+	}
+	return matchDomain(email[i+1:], constraint)
+}
+
+// parseIPRange parses a CIDR range of a name constraint.
+func parseIPRange(s string) (*net.IPNet, error) {
+	_, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ip range: %q", s)
+	}
+	return ipNet, nil
+}
@@ -0,0 +1,112 @@
+package cert
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"testing"
+)
+
+func TestNameConstraints(t *testing.T) {
+	store := NewMemStore()
+
+	_, ipNet, _ := net.ParseCIDR("10.0.0.0/8")
+	root := profiles.CA
+	root.MaxPathLen = 1
+	root.NameConstraints = NameConstraints{
+		PermittedDNSDomains:     []string{"dev.acme.test"},
+		ExcludedDNSDomains:      []string{"db.dev.acme.test"},
+		PermittedIPRanges:       []*net.IPNet{ipNet},
+		PermittedEmailAddresses: []string{"acme.test"},
+	}
+	if err := CreateCACert(store, "root", root); err != nil {
+		t.Fatalf("create root cert: %v", err)
+	}
+	if err := CreateIntermediateCACert(store, "root", "ca", profiles.CA); err != nil {
+		t.Fatalf("create intermediate cert: %v", err)
+	}
+
+	for _, ca := range []string{"root", "ca"} {
+		server := profiles.Server
+		server.DNSNames = []string{"api.dev.acme.test", "*.svc.dev.acme.test"}
+		server.IPAddresses = []net.IP{net.IPv4(10, 1, 2, 3)}
+		if err := CreateServerCert(store, ca, "api-"+ca, server); err != nil {
+			t.Fatalf("create server cert: %v", err)
+		}
+
+		client := profiles.Client
+		client.EmailAddresses = []string{"bob@acme.test", "alice@ops.acme.test"}
+		if err := CreateClientCert(store, ca, "bob-"+ca, client); err != nil {
+			t.Fatalf("create client cert: %v", err)
+		}
+
+		for _, tt := range []struct {
+			dnsName string
+			ip      net.IP
+			email   string
+		}{
+			{dnsName: "api.prod.acme.test"},
+			{dnsName: "dev.acme.test.evil"},
+			{dnsName: "db.dev.acme.test"},
+			{ip: net.IPv4(192, 168, 1, 1)},
+			{email: "bob@evil.test"},
+		} {
+			server := profiles.Server
+			server.DNSNames = nil
+			server.IPAddresses = nil
+			if tt.dnsName != "" {
+				server.DNSNames = []string{tt.dnsName}
+			}
+			if tt.ip != nil {
+				server.IPAddresses = []net.IP{tt.ip}
+			}
+			if tt.email != "" {
+				server.DNSNames = []string{"www.dev.acme.test"}
+				server.EmailAddresses = []string{tt.email}
+			}
+
+			err := CreateServerCert(store, ca, "forbidden", server)
+			if _, ok := err.(*PolicyError); !ok {
+				t.Fatalf("%s: unexpected error: %v for %+v", ca, err, tt)
+			}
+		}
+	}
+
+	// the constraints are enforced by x509 verification
+	roots, err := NewCertPool(store, "root", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := ReadCertChain(store, "api-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(chain[0], chain[1:], roots, x509.ExtKeyUsageServerAuth, "www.svc.dev.acme.test"); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	// a CSR outside of the constraints is refused
+	key, err := GenerateKey(ECDSAP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := NewCSR(key, "www.prod.acme.test", Profile{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+	policy := Policy{DNSSuffixes: []string{"acme.test"}}
+	_, err = IssueCSR(store, "ca", ServerLeaf, csr, profiles.Server, policy)
+	if _, ok := err.(*PolicyError); !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the constraints of the issuers are not skipped on a broken index
+	if err := store.WriteFile(indexFile, []byte("broken\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	err = checkIssuerConstraints(store, "ca", chain[0])
+	if _, ok := err.(*PolicyError); err == nil || ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
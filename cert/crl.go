@@ -0,0 +1,288 @@
+package cert
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+// RevocationReason is the CRL reason code of a revoked certificate (RFC 5280).
+type RevocationReason int
+
+const (
+	ReasonUnspecified          RevocationReason = 0
+	ReasonKeyCompromise        RevocationReason = 1
+	ReasonCACompromise         RevocationReason = 2
+	ReasonAffiliationChanged   RevocationReason = 3
+	ReasonSuperseded           RevocationReason = 4
+	ReasonCessationOfOperation RevocationReason = 5
+	ReasonCertificateHold      RevocationReason = 6
+	ReasonRemoveFromCRL        RevocationReason = 8
+	ReasonPrivilegeWithdrawn   RevocationReason = 9
+	ReasonAACompromise         RevocationReason = 10
+)
+
+// same names as the OpenSSL index.txt
+var revocationReasonNames = map[RevocationReason]string{
+	ReasonUnspecified:          "unspecified",
+	ReasonKeyCompromise:        "keyCompromise",
+	ReasonCACompromise:         "CACompromise",
+	ReasonAffiliationChanged:   "affiliationChanged",
+	ReasonSuperseded:           "superseded",
+	ReasonCessationOfOperation: "cessationOfOperation",
+	ReasonCertificateHold:      "certificateHold",
+	ReasonRemoveFromCRL:        "removeFromCRL",
+	ReasonPrivilegeWithdrawn:   "privilegeWithdrawn",
+	ReasonAACompromise:         "AACompromise",
+}
+
+func (r RevocationReason) String() string {
+	if name, ok := revocationReasonNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("reason(%d)", int(r))
+}
+
+func ParseRevocationReason(s string) (RevocationReason, error) {
+	for reason, name := range revocationReasonNames {
+		if name == s {
+			return reason, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid revocation reason: %q", s)
+}
+
+// Revoke marks the certificate issued by ca with the given serial as revoked
+// in the index. The revocation is published by the next call to GenerateCRL.
+func Revoke(store KeyStore, ca string, serial *big.Int, reason RevocationReason) error {
+	if _, ok := revocationReasonNames[reason]; !ok {
+		return fmt.Errorf("invalid revocation reason: %v", reason)
+	}
+
+	return updateIndex(store, func(records []Record) ([]Record, error) {
+		for i, record := range records {
+			if record.Issuer != ca || record.Serial.Cmp(serial) != 0 {
+				continue
+			}
+			if record.Status == StatusRevoked {
+				return nil, fmt.Errorf("certificate %X already revoked", serial)
+			}
+
+			log.Printf("revoking %q certificate %X: %v\n", record.Name, serial, reason)
+			records[i].Status = StatusRevoked
+			records[i].RevokedAt = time.Now()
+			records[i].Reason = reason
+			return records, nil
+		}
+		return nil, ErrNotFound
+	})
+}
+
+// supersede revokes the valid certificate with the given serial, whatever
+// its issuer, as superseded. The certificates missing from the index are
+// ignored.
+func supersede(store KeyStore, serial *big.Int) error {
+	return updateIndex(store, func(records []Record) ([]Record, error) {
+		for i, record := range records {
+			if record.Serial.Cmp(serial) != 0 || record.Status != StatusValid {
+				continue
+			}
+
+			log.Printf("superseding %q certificate %X\n", record.Name, serial)
+			records[i].Status = StatusRevoked
+			records[i].RevokedAt = time.Now()
+			records[i].Reason = ReasonSuperseded
+		}
+		return records, nil
+	})
+}
+
+// GenerateCRL writes to store the <ca>.crl listing the certificates
+// revoked by ca, valid for the given duration.
+func GenerateCRL(store KeyStore, ca string, validity time.Duration) error {
+	tlsCA, err := ReadTLSCert(store, ca, ca)
+	if err != nil {
+		return fmt.Errorf("read ca cert: %v", err)
+	}
+
+	records, err := FindRecords(store, func(r Record) bool {
+		return r.Issuer == ca && r.Status == StatusRevoked
+	})
+	if err != nil {
+		return fmt.Errorf("read index: %v", err)
+	}
+
+	var revoked []x509.RevocationListEntry
+	for _, record := range records {
+		revoked = append(revoked, x509.RevocationListEntry{
+			SerialNumber:   record.Serial,
+			RevocationTime: record.RevokedAt,
+			ReasonCode:     int(record.Reason),
+		})
+	}
+
+	now := time.Now()
+	template := x509.RevocationList{
+		// a time based number is monotonically increasing
+		Number:                    big.NewInt(now.UnixNano()),
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(validity),
+		RevokedCertificateEntries: revoked,
+	}
+
+	signer, ok := tlsCA.PrivateKey.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("unsupported key: %T", tlsCA.PrivateKey)
+	}
+
+	der, err := x509.CreateRevocationList(
+		rand.Reader,
+		&template,
+		tlsCA.Leaf,
+		signer,
+	)
+	if err != nil {
+		return fmt.Errorf("encode crl: %v", err)
+	}
+
+	name := ca + ".crl"
+	log.Printf("writing %q crl to %q\n", ca, name)
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+	return store.WriteFile(name, data, 0644)
+}
+
+// ReadCRL reads the <ca>.crl and checks its signature.
+func ReadCRL(store KeyStore, ca string) (*x509.RevocationList, error) {
+	issuer, err := ReadCert(store, ca)
+	if err != nil {
+		return nil, fmt.Errorf("read ca cert: %v", err)
+	}
+
+	return readCRL(store, ca+".crl", issuer)
+}
+
+func readCRL(store KeyStore, name string, issuer *x509.Certificate) (*x509.RevocationList, error) {
+	log.Printf("reading crl from %q\n", name)
+
+	data, err := store.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no pem data found")
+	}
+
+	if block.Type != "X509 CRL" {
+		return nil, fmt.Errorf("invalid type: %v", block.Type)
+	}
+
+	crl, err := x509.ParseRevocationList(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("decode crl: %v", err)
+	}
+
+	if err = crl.CheckSignatureFrom(issuer); err != nil {
+		return nil, fmt.Errorf("check crl signature: %v", err)
+	}
+
+	return crl, nil
+}
+
+// crlChecker verifies the peer certificates against a CRL file,
+// which is reloaded when it changes in the store.
+type crlChecker struct {
+	store  KeyStore
+	name   string
+	issuer *x509.Certificate
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	crl     *x509.RevocationList
+}
+
+func newCRLChecker(store KeyStore, ca string) (*crlChecker, error) {
+	issuer, err := ReadCert(store, ca)
+	if err != nil {
+		return nil, fmt.Errorf("read ca cert: %v", err)
+	}
+
+	return &crlChecker{
+		store:  store,
+		name:   ca + ".crl",
+		issuer: issuer,
+	}, nil
+}
+
+// load returns the current CRL, nil if there is no CRL file.
+func (c *crlChecker) load() (*x509.RevocationList, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info, err := c.store.Stat(c.name)
+	if os.IsNotExist(err) {
+		c.crl = nil
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if c.crl != nil && info.ModTime().Equal(c.modTime) && info.Size() == c.size {
+		return c.crl, nil
+	}
+
+	crl, err := readCRL(c.store, c.name, c.issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	c.crl = crl
+	c.modTime = info.ModTime()
+	c.size = info.Size()
+	return crl, nil
+}
+
+// VerifyConnection is the tls.Config VerifyConnection, which unlike
+// VerifyPeerCertificate is also called for resumed sessions.
+func (c *crlChecker) VerifyConnection(cs tls.ConnectionState) error {
+	verifiedChains := cs.VerifiedChains
+	if len(verifiedChains) == 0 {
+		return nil
+	}
+
+	crl, err := c.load()
+	if err != nil {
+		return fmt.Errorf("load crl: %v", err)
+	}
+	if crl == nil {
+		return nil
+	}
+
+	for _, chain := range verifiedChains {
+		for _, cert := range chain {
+			if !bytes.Equal(cert.RawIssuer, crl.RawIssuer) {
+				continue
+			}
+			for _, entry := range crl.RevokedCertificateEntries {
+				if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+					return fmt.Errorf("certificate %X revoked", cert.SerialNumber)
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package cert
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRevoke(t *testing.T) {
+	store := NewMemStore()
+
+	err := CreateCerts(store, "ca", "localhost", "client", profiles)
+	if err != nil {
+		t.Fatalf("create certs: %v", err)
+	}
+
+	get := func(url string) error {
+		client, err := NewTLSClient(store, "ca", "client")
+		if err != nil {
+			t.Fatalf("create client: %v", err)
+		}
+		res, err := client.Get(url)
+		if err != nil {
+			return err
+		}
+		return res.Body.Close()
+	}
+
+	withServerPKI(store, func(url string) {
+		if err := get(url); err != nil {
+			t.Fatalf("unexpected error without crl: %v", err)
+		}
+
+		if err := GenerateCRL(store, "ca", time.Hour); err != nil {
+			t.Fatalf("generate crl: %v", err)
+		}
+		if err := get(url); err != nil {
+			t.Fatalf("unexpected error with empty crl: %v", err)
+		}
+
+		client, err := ReadCert(store, "client")
+		if err != nil {
+			t.Fatalf("read client cert: %v", err)
+		}
+		err = Revoke(store, "ca", client.SerialNumber, ReasonKeyCompromise)
+		if err != nil {
+			t.Fatalf("revoke: %v", err)
+		}
+		err = Revoke(store, "ca", client.SerialNumber, ReasonKeyCompromise)
+		if err == nil {
+			t.Fatal("expected error revoking twice")
+		}
+
+		record, err := FindRecord(store, client.SerialNumber)
+		if err != nil {
+			t.Fatalf("find record: %v", err)
+		}
+		if record.Status != StatusRevoked || record.Reason != ReasonKeyCompromise || record.RevokedAt.IsZero() {
+			t.Fatalf("unexpected record: %v", record)
+		}
+
+		if err := GenerateCRL(store, "ca", time.Hour); err != nil {
+			t.Fatalf("generate crl: %v", err)
+		}
+
+		crl, err := ReadCRL(store, "ca")
+		if err != nil {
+			t.Fatalf("read crl: %v", err)
+		}
+		if len(crl.RevokedCertificateEntries) != 1 {
+			t.Fatalf("unexpected number of entries: %d", len(crl.RevokedCertificateEntries))
+		}
+		entry := crl.RevokedCertificateEntries[0]
+		if entry.SerialNumber.Cmp(client.SerialNumber) != 0 || entry.ReasonCode != int(ReasonKeyCompromise) {
+			t.Fatalf("unexpected entry: %+v", entry)
+		}
+
+		if err := get(url); err == nil {
+			t.Fatal("expected error with revoked client cert")
+		}
+	}, WithCRL())
+}
+
+func TestRevokeResumedSession(t *testing.T) {
+	store := NewMemStore()
+
+	err := CreateCerts(store, "ca", "localhost", "client", profiles)
+	if err != nil {
+		t.Fatalf("create certs: %v", err)
+	}
+
+	withServerPKI(store, func(url string) {
+		client, err := NewTLSClient(store, "ca", "client", WithSessionResumption(0))
+		if err != nil {
+			t.Fatalf("create client: %v", err)
+		}
+		get := func() (*http.Response, error) {
+			defer client.CloseIdleConnections()
+			res, err := client.Get(url)
+			if err != nil {
+				return nil, err
+			}
+			ioutil.ReadAll(res.Body)
+			return res, res.Body.Close()
+		}
+
+		for i := 0; i < 2; i++ {
+			res, err := get()
+			if err != nil {
+				t.Fatalf("unexpected error before revocation: %v", err)
+			}
+			if res.TLS.DidResume != (i == 1) {
+				t.Fatalf("unexpected resumption of connection %d", i)
+			}
+		}
+
+		cert, err := ReadCert(store, "client")
+		if err != nil {
+			t.Fatalf("read client cert: %v", err)
+		}
+		if err := Revoke(store, "ca", cert.SerialNumber, ReasonKeyCompromise); err != nil {
+			t.Fatalf("revoke: %v", err)
+		}
+		if err := GenerateCRL(store, "ca", time.Hour); err != nil {
+			t.Fatalf("generate crl: %v", err)
+		}
+
+		if _, err := get(); err == nil {
+			t.Fatal("expected error with revoked client cert on a resumed session")
+		}
+	}, WithCRL(), WithSessionResumption(0))
+}
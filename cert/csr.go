@@ -0,0 +1,270 @@
+package cert
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Policy restricts the certificates issued from certificate requests.
+type Policy struct {
+	// DNSSuffixes lists the domains allowed in the DNS names, the email
+	// addresses and the URIs of the requests, no domain is allowed when empty.
+	DNSSuffixes []string
+	// AllowIPAddresses allows IP addresses in the requests.
+	AllowIPAddresses bool
+	// KeyTypes lists the allowed key types, any type is allowed when empty.
+	KeyTypes []KeyType
+	// MaxValidity limits the validity of the certificates, when non-zero.
+	MaxValidity time.Duration
+
+	// ServerRequesters lists the common names, email addresses or URIs
+	// of the client certificates allowed to request server certificates
+	// from CAHandler, none when empty.
+	ServerRequesters []string
+}
+
+// PolicyError reports a certificate request rejected by a Policy.
+type PolicyError struct {
+	Reason string
+}
+
+func (e *PolicyError) Error() string {
+	return "policy: " + e.Reason
+}
+
+func policyErrorf(format string, a ...interface{}) error {
+	return &PolicyError{Reason: fmt.Sprintf(format, a...)}
+}
+
+func (p Policy) allowDomain(name string) bool {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	for _, suffix := range p.DNSSuffixes {
+		suffix = strings.TrimPrefix(strings.ToLower(suffix), ".")
+		if name == suffix || strings.HasSuffix(name, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Policy) check(csr *x509.CertificateRequest, validity time.Duration) error {
+	keyType, err := PublicKeyType(csr.PublicKey)
+	if err != nil {
+		return policyErrorf("%v", err)
+	}
+	if len(p.KeyTypes) > 0 {
+		allowed := false
+		for _, t := range p.KeyTypes {
+			allowed = allowed || t == keyType
+		}
+		if !allowed {
+			return policyErrorf("key type not allowed: %v", keyType)
+		}
+	}
+
+	if p.MaxValidity > 0 && validity > p.MaxValidity {
+		return policyErrorf("validity %v exceeds %v", validity, p.MaxValidity)
+	}
+
+	for _, name := range csr.DNSNames {
+		if !p.allowDomain(strings.TrimPrefix(name, "*.")) {
+			return policyErrorf("dns name not allowed: %q", name)
+		}
+	}
+	for _, email := range csr.EmailAddresses {
+		i := strings.LastIndex(email, "@")
+		if i < 0 || !p.allowDomain(email[i+1:]) {
+			return policyErrorf("email address not allowed: %q", email)
+		}
+	}
+	for _, uri := range csr.URIs {
+		if !p.allowDomain(uri.Hostname()) {
+			return policyErrorf("uri not allowed: %q", uri)
+		}
+	}
+	if len(csr.IPAddresses) > 0 && !p.AllowIPAddresses {
+		return policyErrorf("ip address not allowed: %v", csr.IPAddresses[0])
+	}
+
+	return p.checkCommonName(csr.Subject.CommonName)
+}
+
+// checkCommonName checks the common names that look like an email address,
+// an IP address or a domain name like the SANs. The other names only name
+// the certificates, they are limited to letters, digits, '-' and '_'. The
+// control characters, which would corrupt the index, are always refused.
+func (p Policy) checkCommonName(cn string) error {
+	if strings.IndexFunc(cn, unicode.IsControl) >= 0 {
+		return policyErrorf("common name not allowed: %q", cn)
+	}
+
+	switch {
+	case strings.Contains(cn, "@"):
+		i := strings.LastIndex(cn, "@")
+		if !p.allowDomain(cn[i+1:]) {
+			return policyErrorf("common name not allowed: %q", cn)
+		}
+	case net.ParseIP(cn) != nil:
+		if !p.AllowIPAddresses {
+			return policyErrorf("common name not allowed: %q", cn)
+		}
+	case strings.Contains(cn, "."):
+		if !p.allowDomain(strings.TrimPrefix(cn, "*.")) {
+			return policyErrorf("common name not allowed: %q", cn)
+		}
+	default:
+		for _, r := range cn {
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' {
+				return policyErrorf("common name not allowed: %q", cn)
+			}
+		}
+	}
+	return nil
+}
+
+// NewCSR returns a DER encoded certificate request for cn, signed by key,
+// with the subject fields and the SANs of the profile.
+func NewCSR(key crypto.Signer, cn string, profile Profile) ([]byte, error) {
+	csr := x509.CertificateRequest{
+		Subject:        profile.subject(cn),
+		DNSNames:       profile.DNSNames,
+		IPAddresses:    profile.IPAddresses,
+		EmailAddresses: profile.EmailAddresses,
+		URIs:           profile.URIs,
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, &csr, key)
+	if err != nil {
+		return nil, fmt.Errorf("encode csr: %v", err)
+	}
+
+	return der, nil
+}
+
+// CreateCSR writes to store a <cn>.csr for the existing <cn>.key,
+// so that the private key never leaves the requester.
+func CreateCSR(store KeyStore, cn, password string, profile Profile) error {
+	key, err := ReadKey(store, cn, password)
+	if err != nil {
+		return fmt.Errorf("read key: %v", err)
+	}
+
+	der, err := NewCSR(key, cn, profile)
+	if err != nil {
+		return err
+	}
+
+	name := cn + ".csr"
+	log.Printf("writing %q csr to %q\n", cn, name)
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+	return store.WriteFile(name, data, 0644)
+}
+
+// ParseCSR decodes a PEM encoded certificate request and checks its signature.
+func ParseCSR(data []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no pem data found")
+	}
+
+	if block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST" {
+		return nil, fmt.Errorf("invalid type: %v", block.Type)
+	}
+
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("decode csr: %v", err)
+	}
+
+	if err = csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("check csr signature: %v", err)
+	}
+
+	return csr, nil
+}
+
+// IssueCSR signs a PEM encoded certificate request with ca after checking it
+// against the policy, a rejected request returns a *PolicyError. The common
+// name and the SANs come from the request, the other fields, including the
+// rest of the subject, from the profile.
+// It returns the PEM encoded certificate followed by the chain of the CA,
+// the certificate is only recorded in the index of store.
+func IssueCSR(store KeyStore, ca string, kind LeafKind, data []byte, profile Profile, policy Policy) ([]byte, error) {
+	csr, err := ParseCSR(data)
+	if err != nil {
+		return nil, err
+	}
+
+	cn := csr.Subject.CommonName
+	if cn == "" {
+		return nil, fmt.Errorf("missing common name")
+	}
+
+	if kind == ServerLeaf && len(csr.DNSNames) == 0 && len(csr.IPAddresses) == 0 {
+		csr.DNSNames, csr.IPAddresses = serverNames(cn)
+	}
+
+	if err = policy.check(csr, profile.Validity); err != nil {
+		return nil, err
+	}
+
+	cert, err := profile.template(cn)
+	if err != nil {
+		return nil, fmt.Errorf("create template: %v", err)
+	}
+
+	// the subject of the request is not trusted, but its common name
+	cert.DNSNames = csr.DNSNames
+	cert.IPAddresses = csr.IPAddresses
+	cert.EmailAddresses = csr.EmailAddresses
+	cert.URIs = csr.URIs
+
+	switch kind {
+	case ServerLeaf:
+		setServerUsage(&cert, csr.PublicKey)
+	case ClientLeaf:
+		setClientUsage(&cert)
+	default:
+		return nil, fmt.Errorf("invalid kind: %q", kind)
+	}
+
+	tlsCA, err := ReadTLSCert(store, ca, ca)
+	if err != nil {
+		return nil, fmt.Errorf("read ca cert: %v", err)
+	}
+
+	cert.SerialNumber, err = newSerialNumber()
+	if err != nil {
+		return nil, fmt.Errorf("generate serial number: %v", err)
+	}
+
+	if err := checkIssuerConstraints(store, ca, &cert); err != nil {
+		return nil, err
+	}
+
+	blocks, err := signCert(&cert, csr.PublicKey, tlsCA)
+	if err != nil {
+		return nil, err
+	}
+
+	err = recordCert(store, ca, cn, blocks[0].Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("record cert: %v", err)
+	}
+
+	var chain []byte
+	for _, block := range blocks {
+		chain = append(chain, pem.EncodeToMemory(block)...)
+	}
+
+	return chain, nil
+}
@@ -0,0 +1,150 @@
+package cert
+
+import (
+	"encoding/pem"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIssueCSR(t *testing.T) {
+	store := NewMemStore()
+
+	if err := CreateCACert(store, "ca", profiles.CA); err != nil {
+		t.Fatalf("create ca cert: %v", err)
+	}
+
+	// the requester keeps its key in its own store
+	requester := NewMemStore()
+	key, err := GenerateKey(ECDSAP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveKey(requester, "api", "", key); err != nil {
+		t.Fatal(err)
+	}
+
+	// the subject comes from the profile of the ca
+	request := Profile{
+		Organization:       []string{"acme"},
+		OrganizationalUnit: []string{"admin"},
+		DNSNames:           []string{"api.washingmachine.test", "*.api.washingmachine.test"},
+	}
+	if err := CreateCSR(requester, "api", "", request); err != nil {
+		t.Fatalf("create csr: %v", err)
+	}
+	csr, err := requester.ReadFile("api.csr")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := Policy{
+		DNSSuffixes: []string{"washingmachine.test"},
+		KeyTypes:    []KeyType{ECDSAP256, Ed25519},
+		MaxValidity: time.Hour,
+	}
+
+	data, err := IssueCSR(store, "ca", ServerLeaf, csr, profiles.Server, policy)
+	if err != nil {
+		t.Fatalf("issue csr: %v", err)
+	}
+
+	chain, err := ParseCertChain(data)
+	if err != nil {
+		t.Fatalf("parse chain: %v", err)
+	}
+	leaf := chain[0]
+	if leaf.Subject.String() != "CN=api,O=washingmachine,ST=france,C=EU" {
+		t.Fatalf("unexpected subject: %v", leaf.Subject)
+	}
+	if strings.Join(leaf.DNSNames, " ") != "api.washingmachine.test *.api.washingmachine.test" {
+		t.Fatalf("unexpected dns names: %v", leaf.DNSNames)
+	}
+	if err := leaf.VerifyHostname("www.api.washingmachine.test"); err != nil {
+		t.Fatal(err)
+	}
+
+	ca, err := ReadCert(store, "ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leaf.CheckSignatureFrom(ca); err != nil {
+		t.Fatalf("check signature: %v", err)
+	}
+
+	if _, err := FindRecord(store, leaf.SerialNumber); err != nil {
+		t.Fatalf("find record: %v", err)
+	}
+	if _, err := store.Stat("api.crt"); !os.IsNotExist(err) {
+		t.Fatalf("unexpected certificate file: %v", err)
+	}
+
+	for name, tc := range map[string]struct {
+		keyType KeyType
+		profile Profile
+		policy  Policy
+	}{
+		"dns": {
+			keyType: ECDSAP256,
+			profile: Profile{DNSNames: []string{"api.production.test"}},
+			policy:  policy,
+		},
+		"ip": {
+			keyType: ECDSAP256,
+			profile: Profile{IPAddresses: []net.IP{net.IPv4(10, 0, 0, 1)}},
+			policy:  policy,
+		},
+		"cn": {
+			keyType: ECDSAP256,
+			policy:  policy,
+		},
+		"key type": {
+			keyType: RSA2048,
+			profile: Profile{DNSNames: []string{"api.washingmachine.test"}},
+			policy:  policy,
+		},
+		"validity": {
+			keyType: ECDSAP256,
+			profile: Profile{DNSNames: []string{"api.washingmachine.test"}},
+			policy:  Policy{DNSSuffixes: policy.DNSSuffixes, MaxValidity: time.Second},
+		},
+	} {
+		key, err := GenerateKey(tc.keyType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := NewCSR(key, "api", tc.profile)
+		if err != nil {
+			t.Fatalf("%s: new csr: %v", name, err)
+		}
+		csr := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+
+		if _, err := IssueCSR(store, "ca", ServerLeaf, csr, profiles.Server, tc.policy); err == nil {
+			t.Fatalf("%s: expected policy error", name)
+		}
+	}
+
+	// the common names are checked like the SANs
+	key, err = GenerateKey(ECDSAP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cn := range []string{
+		"admin.production.test", "admin@production.test", "10.0.0.1",
+		// the control characters would corrupt the index
+		"api\tV", "api\nV", "api.washingmachine.test\n", "admin/api",
+	} {
+		der, err := NewCSR(key, cn, Profile{DNSNames: []string{"api.washingmachine.test"}})
+		if err != nil {
+			t.Fatalf("%s: new csr: %v", cn, err)
+		}
+		csr := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+
+		_, err = IssueCSR(store, "ca", ClientLeaf, csr, profiles.Client, policy)
+		if _, ok := err.(*PolicyError); !ok {
+			t.Fatalf("%s: expected policy error: %v", cn, err)
+		}
+	}
+}
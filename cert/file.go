@@ -0,0 +1,33 @@
+package cert
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic writes data to a temporary file renamed to path,
+// so that readers never see a partially written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err = file.Chmod(perm); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err = file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
@@ -1,4 +1,13 @@
 module github.com/schorlet/exp/cert
 
-go 1.13
+go 1.21
 
+require (
+	github.com/gorilla/websocket v1.5.3
+	golang.org/x/crypto v0.11.0
+	golang.org/x/net v0.10.0
+	gopkg.in/yaml.v3 v3.0.1
+	software.sslmate.com/src/go-pkcs12 v0.4.0
+)
+
+require golang.org/x/text v0.11.0 // indirect
@@ -17,7 +17,9 @@ func HelloHandler(world string) http.HandlerFunc {
 		log.Printf("%s", dump)
 
 		who := world
-		if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		if id, err := PeerIdentity(r); err == nil {
+			who = id.String()
+		} else if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
 			if len(r.TLS.PeerCertificates[0].EmailAddresses) > 0 {
 				who = r.TLS.PeerCertificates[0].EmailAddresses[0]
 			}
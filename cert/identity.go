@@ -0,0 +1,147 @@
+package cert
+
+// https://github.com/spiffe/spiffe/blob/main/standards/SPIFFE-ID.md
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrNoIdentity is returned for a certificate or a request without identity.
+var ErrNoIdentity = errors.New("no identity")
+
+// Identity is a SPIFFE ID, spiffe://<trust domain>/<path>, carried by
+// the URI SAN of a certificate. An identity with an empty path, like
+// spiffe://acme.com, names the trust domain.
+type Identity struct {
+	TrustDomain string
+	Path        string
+}
+
+// ParseIdentity parses a SPIFFE ID.
+func ParseIdentity(s string) (Identity, error) {
+	uri, err := url.Parse(s)
+	if err != nil {
+		return Identity{}, fmt.Errorf("invalid identity: %q", s)
+	}
+	return identityFromURI(uri)
+}
+
+func identityFromURI(uri *url.URL) (Identity, error) {
+	if uri.Scheme != "spiffe" {
+		return Identity{}, fmt.Errorf("invalid identity: %q, scheme is not spiffe", uri)
+	}
+	if uri.Host == "" || uri.Port() != "" || uri.User != nil {
+		return Identity{}, fmt.Errorf("invalid identity: %q, invalid trust domain", uri)
+	}
+	if uri.RawQuery != "" || uri.Fragment != "" {
+		return Identity{}, fmt.Errorf("invalid identity: %q, query or fragment", uri)
+	}
+	if strings.HasSuffix(uri.Path, "/") || strings.Contains(uri.Path, "//") {
+		return Identity{}, fmt.Errorf("invalid identity: %q, empty path segment", uri)
+	}
+	// the spiffe ids are compared as strings, the percent-encoding is forbidden
+	if uri.RawPath != "" || strings.Contains(uri.EscapedPath(), "%") {
+		return Identity{}, fmt.Errorf("invalid identity: %q, percent-encoded path", uri)
+	}
+
+	return Identity{
+		TrustDomain: strings.ToLower(uri.Host),
+		Path:        uri.Path,
+	}, nil
+}
+
+// URL returns the URI SAN of the identity, as set in the URIs of a Profile.
+func (id Identity) URL() *url.URL {
+	return &url.URL{Scheme: "spiffe", Host: id.TrustDomain, Path: id.Path}
+}
+
+func (id Identity) String() string {
+	return id.URL().String()
+}
+
+// Match reports whether id is other, or belongs to the trust domain
+// named by other.
+func (id Identity) Match(other Identity) bool {
+	if id.TrustDomain != other.TrustDomain {
+		return false
+	}
+	return other.Path == "" || id.Path == other.Path
+}
+
+// CertIdentity returns the identity of the certificate,
+// which must have exactly one spiffe URI SAN.
+func CertIdentity(cert *x509.Certificate) (Identity, error) {
+	var ids []Identity
+	for _, uri := range cert.URIs {
+		if uri.Scheme != "spiffe" {
+			continue
+		}
+		id, err := identityFromURI(uri)
+		if err != nil {
+			return Identity{}, err
+		}
+		ids = append(ids, id)
+	}
+
+	switch len(ids) {
+	case 0:
+		return Identity{}, ErrNoIdentity
+	case 1:
+		return ids[0], nil
+	default:
+		return Identity{}, fmt.Errorf("%d identities in %s", len(ids), cert.Subject)
+	}
+}
+
+// PeerIdentity returns the identity of the verified client certificate
+// of the request.
+func PeerIdentity(r *http.Request) (Identity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return Identity{}, ErrNoIdentity
+	}
+	return CertIdentity(r.TLS.VerifiedChains[0][0])
+}
+
+// AuthzHandler authorizes the requests to handler by the identity of their
+// client certificate. The routes map the patterns of http.ServeMux to the
+// allowed identities, the requests to other routes are forbidden.
+//
+// The requests without identity get a 401 Unauthorized response, and the
+// requests of an identity not allowed a 403 Forbidden response.
+func AuthzHandler(handler http.Handler, routes map[string][]Identity) http.Handler {
+	mux := http.NewServeMux()
+	for pattern, allowed := range routes {
+		mux.Handle(pattern, authorize(handler, allowed))
+	}
+	if _, ok := routes["/"]; !ok {
+		mux.Handle("/", authorize(handler, nil))
+	}
+	return mux
+}
+
+func authorize(handler http.Handler, allowed []Identity) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := PeerIdentity(r)
+		if err != nil {
+			log.Printf("authz %s: %v", r.URL.Path, err)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		for _, other := range allowed {
+			if id.Match(other) {
+				handler.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		log.Printf("authz %s: %s not allowed", r.URL.Path, id)
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	}
+}
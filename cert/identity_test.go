@@ -0,0 +1,124 @@
+package cert
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestParseIdentity(t *testing.T) {
+	for s, want := range map[string]Identity{
+		"spiffe://acme.test":               {TrustDomain: "acme.test"},
+		"spiffe://ACME.test/ns/default/sa": {TrustDomain: "acme.test", Path: "/ns/default/sa"},
+	} {
+		id, err := ParseIdentity(s)
+		if err != nil {
+			t.Fatalf("parse %s: %v", s, err)
+		}
+		if id != want {
+			t.Fatalf("unexpected identity of %s: %+v", s, id)
+		}
+	}
+
+	for _, s := range []string{
+		"https://acme.test/api",
+		"spiffe:///api",
+		"spiffe://acme.test:8443/api",
+		"spiffe://bob@acme.test/api",
+		"spiffe://acme.test/api?v=1",
+		"spiffe://acme.test/api/",
+		"spiffe://acme.test//api",
+		"spiffe://acme.test/ns%2Fdefault",
+		"spiffe://acme.test/%61pi",
+		"spiffe://acme.test/a%20b",
+		"spiffe://acme.test/100%25",
+	} {
+		if _, err := ParseIdentity(s); err == nil {
+			t.Fatalf("expected error parsing %s", s)
+		}
+	}
+}
+
+func TestAuthzHandler(t *testing.T) {
+	store := NewMemStore()
+	if err := CreateCerts(store, "ca", "localhost", "client", profiles); err != nil {
+		t.Fatalf("create certs: %v", err)
+	}
+
+	ids := map[string]string{
+		"api":     "spiffe://acme.test/api",
+		"billing": "spiffe://acme.test/billing",
+		"other":   "spiffe://other.test/api",
+	}
+	clients := make(map[string]*http.Client)
+	for name, s := range ids {
+		id, err := ParseIdentity(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		profile := profiles.Client
+		profile.URIs = append(profile.URIs, id.URL())
+		if err := CreateClientCert(store, "ca", name, profile); err != nil {
+			t.Fatalf("create client cert: %v", err)
+		}
+
+		leaf, err := ReadCert(store, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := CertIdentity(leaf); err != nil || got != id {
+			t.Fatalf("unexpected identity of %s: %v %v", name, got, err)
+		}
+
+		if clients[name], err = NewTLSClient(store, "ca", name); err != nil {
+			t.Fatalf("create client: %v", err)
+		}
+	}
+
+	var err error
+	if clients["client"], err = NewTLSClient(store, "ca", "client"); err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	routes := map[string][]Identity{
+		"/hello": {{TrustDomain: "acme.test"}},
+		"/billing/": {
+			{TrustDomain: "acme.test", Path: "/billing"},
+			{TrustDomain: "other.test", Path: "/api"},
+		},
+	}
+
+	withHandler(store, AuthzHandler(HelloHandler("world"), routes), func(url string) {
+		for _, tt := range []struct {
+			client string
+			path   string
+			status int
+		}{
+			{"api", "/hello", http.StatusOK},
+			{"billing", "/hello", http.StatusOK},
+			{"other", "/hello", http.StatusForbidden},
+			{"client", "/hello", http.StatusUnauthorized},
+			{"api", "/billing/invoices", http.StatusForbidden},
+			{"billing", "/billing/invoices", http.StatusOK},
+			{"other", "/billing/invoices", http.StatusOK},
+			{"api", "/", http.StatusForbidden},
+		} {
+			res, err := clients[tt.client].Get(url + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res.StatusCode != tt.status {
+				t.Fatalf("%s %s: unexpected status: %s", tt.client, tt.path, res.Status)
+			}
+			if res.StatusCode == http.StatusOK && string(data) != "hello "+ids[tt.client] {
+				t.Fatalf("%s %s: unexpected greeting: %q", tt.client, tt.path, data)
+			}
+		}
+	})
+}
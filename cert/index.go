@@ -0,0 +1,314 @@
+package cert
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The index is a text file in store, similar to the OpenSSL index.txt,
+// with one line per issued certificate and tab separated columns:
+//
+//	status, not after, revocation date, serial, name, subject, SANs, issuer
+//
+// The SANs are separated by commas. The tabs, the line breaks and the
+// percent signs of the text columns, and the commas of the SANs, are
+// percent-encoded.
+const indexFile = "index.txt"
+
+const indexTimeFormat = "20060102150405Z"
+
+// Status is the status of a certificate in the index.
+type Status string
+
+const (
+	StatusValid   Status = "V"
+	StatusRevoked Status = "R"
+	StatusExpired Status = "E"
+)
+
+var ErrNotFound = errors.New("record not found")
+
+// Record is an entry of the index.
+type Record struct {
+	Status    Status
+	NotAfter  time.Time
+	RevokedAt time.Time
+	Reason    RevocationReason
+	Serial    *big.Int
+	// Name is the common name used to name the .crt and .key files.
+	Name    string
+	Subject string
+	SANs    []string
+	// Issuer is the name of the issuing CA.
+	Issuer string
+}
+
+// indexMu serializes the writes to the index files.
+var indexMu sync.Mutex
+
+// serialLimit bounds the random serial numbers to 128 bits.
+var serialLimit = new(big.Int).Lsh(big.NewInt(1), 128)
+
+func newSerialNumber() (*big.Int, error) {
+	for {
+		serial, err := rand.Int(rand.Reader, serialLimit)
+		if err != nil {
+			return nil, err
+		}
+		if serial.Sign() > 0 {
+			return serial, nil
+		}
+	}
+}
+
+func newRecord(issuer, name string, cert *x509.Certificate) Record {
+	var sans []string
+	sans = append(sans, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+
+	return Record{
+		Status:   StatusValid,
+		NotAfter: cert.NotAfter,
+		Serial:   cert.SerialNumber,
+		Name:     name,
+		Subject:  cert.Subject.String(),
+		SANs:     sans,
+		Issuer:   issuer,
+	}
+}
+
+// Expired reports whether the certificate has expired at t.
+func (r Record) Expired(t time.Time) bool {
+	return r.Status == StatusExpired || t.After(r.NotAfter)
+}
+
+func (r Record) String() string {
+	var revokedAt string
+	if !r.RevokedAt.IsZero() {
+		revokedAt = r.RevokedAt.UTC().Format(indexTimeFormat) + "," + r.Reason.String()
+	}
+
+	sans := make([]string, len(r.SANs))
+	for i, san := range r.SANs {
+		sans[i] = sanEscaper.Replace(san)
+	}
+
+	return strings.Join([]string{
+		string(r.Status),
+		r.NotAfter.UTC().Format(indexTimeFormat),
+		revokedAt,
+		fmt.Sprintf("%X", r.Serial),
+		escapeField(r.Name),
+		escapeField(r.Subject),
+		strings.Join(sans, ","),
+		escapeField(r.Issuer),
+	}, "\t")
+}
+
+var (
+	fieldEscaper = strings.NewReplacer("%", "%25", "\t", "%09", "\n", "%0A", "\r", "%0D")
+	sanEscaper   = strings.NewReplacer("%", "%25", "\t", "%09", "\n", "%0A", "\r", "%0D", ",", "%2C")
+)
+
+func escapeField(s string) string {
+	return fieldEscaper.Replace(s)
+}
+
+func unescapeField(s string) (string, error) {
+	if !strings.Contains(s, "%") {
+		return s, nil
+	}
+	return url.PathUnescape(s)
+}
+
+func parseRecord(line string) (Record, error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) != 8 {
+		return Record{}, fmt.Errorf("invalid number of fields: %d", len(fields))
+	}
+
+	notAfter, err := time.Parse(indexTimeFormat, fields[1])
+	if err != nil {
+		return Record{}, fmt.Errorf("invalid not after: %v", err)
+	}
+
+	var revokedAt time.Time
+	var reason RevocationReason
+	if fields[2] != "" {
+		date := strings.SplitN(fields[2], ",", 2)
+		revokedAt, err = time.Parse(indexTimeFormat, date[0])
+		if err != nil {
+			return Record{}, fmt.Errorf("invalid revocation date: %v", err)
+		}
+		if len(date) == 2 {
+			reason, err = ParseRevocationReason(date[1])
+			if err != nil {
+				return Record{}, err
+			}
+		}
+	}
+
+	serial, ok := new(big.Int).SetString(fields[3], 16)
+	if !ok {
+		return Record{}, fmt.Errorf("invalid serial: %q", fields[3])
+	}
+
+	var sans []string
+	if fields[6] != "" {
+		sans = strings.Split(fields[6], ",")
+	}
+	for i, san := range sans {
+		if sans[i], err = unescapeField(san); err != nil {
+			return Record{}, fmt.Errorf("invalid san: %v", err)
+		}
+	}
+
+	text := []string{fields[4], fields[5], fields[7]}
+	for i, field := range text {
+		if text[i], err = unescapeField(field); err != nil {
+			return Record{}, fmt.Errorf("invalid field: %v", err)
+		}
+	}
+
+	return Record{
+		Status:    Status(fields[0]),
+		NotAfter:  notAfter,
+		RevokedAt: revokedAt,
+		Reason:    reason,
+		Serial:    serial,
+		Name:      text[0],
+		Subject:   text[1],
+		SANs:      sans,
+		Issuer:    text[2],
+	}, nil
+}
+
+// ReadIndex returns all the records of the index in store,
+// in issuance order.
+func ReadIndex(store KeyStore) ([]Record, error) {
+	log.Printf("reading index from %q\n", indexFile)
+
+	data, err := store.ReadFile(indexFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return readRecords(bytes.NewReader(data))
+}
+
+func readRecords(r io.Reader) ([]Record, error) {
+	var records []Record
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		if scanner.Text() == "" {
+			continue
+		}
+		record, err := parseRecord(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read index: %v", err)
+	}
+
+	return records, nil
+}
+
+// FindRecord returns the record of the certificate with the given serial.
+func FindRecord(store KeyStore, serial *big.Int) (Record, error) {
+	records, err := ReadIndex(store)
+	if err != nil {
+		return Record{}, err
+	}
+
+	for _, record := range records {
+		if record.Serial.Cmp(serial) == 0 {
+			return record, nil
+		}
+	}
+
+	return Record{}, ErrNotFound
+}
+
+// FindRecords returns the records matching the given predicate.
+func FindRecords(store KeyStore, match func(Record) bool) ([]Record, error) {
+	records, err := ReadIndex(store)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []Record
+	for _, record := range records {
+		if match(record) {
+			found = append(found, record)
+		}
+	}
+
+	return found, nil
+}
+
+func appendRecord(store KeyStore, record Record) error {
+	log.Printf("writing %q record to %q\n", record.Name, indexFile)
+
+	return updateIndex(store, func(records []Record) ([]Record, error) {
+		return append(records, record), nil
+	})
+}
+
+// updateIndex rewrites the index with the records returned by update.
+func updateIndex(store KeyStore, update func([]Record) ([]Record, error)) error {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+
+	records, err := ReadIndex(store)
+	if err != nil {
+		return err
+	}
+
+	records, err = update(records)
+	if err != nil {
+		return err
+	}
+
+	var buf strings.Builder
+	for _, record := range records {
+		fmt.Fprintln(&buf, record)
+	}
+
+	log.Printf("writing index to %q\n", indexFile)
+
+	return store.WriteFile(indexFile, []byte(buf.String()), 0600)
+}
+
+func recordCert(store KeyStore, issuer, name string, der []byte) error {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return fmt.Errorf("decode cert: %v", err)
+	}
+
+	return appendRecord(store, newRecord(issuer, name, cert))
+}
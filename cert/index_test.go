@@ -0,0 +1,109 @@
+package cert
+
+import (
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIndex(t *testing.T) {
+	store := NewMemStore()
+
+	err := CreateCerts(store, "ca", "localhost", "client", profiles)
+	if err != nil {
+		t.Fatalf("create certs: %v", err)
+	}
+
+	server := profiles.Server
+	server.DNSNames = []string{"www.washingmachine.test"}
+	server.IPAddresses = nil
+	err = CreateServerCert(store, "ca", "www", server)
+	if err != nil {
+		t.Fatalf("create server cert: %v", err)
+	}
+
+	records, err := ReadIndex(store)
+	if err != nil {
+		t.Fatalf("read index: %v", err)
+	}
+	if len(records) != 4 {
+		t.Fatalf("unexpected number of records: %d", len(records))
+	}
+
+	serials := make(map[string]bool)
+	for i, name := range []string{"ca", "localhost", "client", "www"} {
+		record := records[i]
+		if record.Name != name || record.Issuer != "ca" || record.Status != StatusValid {
+			t.Fatalf("unexpected record: %v", record)
+		}
+		if record.Serial.BitLen() < 64 || serials[record.Serial.String()] {
+			t.Fatalf("unexpected serial: %v", record.Serial)
+		}
+		serials[record.Serial.String()] = true
+
+		var password string
+		if name == "ca" {
+			password = "ca"
+		}
+		tlsCert, err := ReadTLSCert(store, name, password)
+		if err != nil {
+			t.Fatalf("read cert: %v", err)
+		}
+		if tlsCert.Leaf.SerialNumber.Cmp(record.Serial) != 0 {
+			t.Fatalf("unexpected serial: %v", record.Serial)
+		}
+	}
+
+	if !reflect.DeepEqual(records[3].SANs, []string{"www.washingmachine.test"}) {
+		t.Fatalf("unexpected sans: %v", records[3].SANs)
+	}
+
+	record, err := FindRecord(store, records[2].Serial)
+	if err != nil {
+		t.Fatalf("find record: %v", err)
+	}
+	if record.Subject != "CN=client,O=washingmachine,ST=france,C=EU" {
+		t.Fatalf("unexpected subject: %v", record.Subject)
+	}
+
+	_, err = FindRecord(store, big.NewInt(42))
+	if err != ErrNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	found, err := FindRecords(store, func(r Record) bool {
+		return r.Name != r.Issuer
+	})
+	if err != nil {
+		t.Fatalf("find records: %v", err)
+	}
+	if len(found) != 3 {
+		t.Fatalf("unexpected number of records: %d", len(found))
+	}
+}
+
+func TestRecordEscaping(t *testing.T) {
+	record := Record{
+		Status:   StatusValid,
+		NotAfter: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+		Serial:   big.NewInt(42),
+		Name:     "api",
+		Subject:  "CN=api,O=tab\there\nand there,OU=100%",
+		SANs:     []string{"api.test", "spiffe://test/a,b", "https://test/a%2Cb"},
+		Issuer:   "ca",
+	}
+
+	line := record.String()
+	if strings.ContainsAny(line, "\n\r") || strings.Count(line, "\t") != 7 {
+		t.Fatalf("unescaped record: %q", line)
+	}
+	parsed, err := parseRecord(line)
+	if err != nil {
+		t.Fatalf("parse record: %v", err)
+	}
+	if !reflect.DeepEqual(parsed, record) {
+		t.Fatalf("got %+v, want %+v", parsed, record)
+	}
+}
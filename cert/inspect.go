@@ -0,0 +1,246 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Summary describes a certificate, as returned by Inspect.
+type Summary struct {
+	Subject string `json:"subject"`
+	Issuer  string `json:"issuer"`
+	Serial  string `json:"serial"`
+
+	DNSNames       []string `json:"dns_names,omitempty"`
+	IPAddresses    []string `json:"ip_addresses,omitempty"`
+	EmailAddresses []string `json:"email_addresses,omitempty"`
+	URIs           []string `json:"uris,omitempty"`
+
+	// KeyAlgorithm is RSA, ECDSA or Ed25519, KeySize is the size in bits
+	// of the RSA modulus or of the curve. KeyType is empty for the keys
+	// not generated by GenerateKey.
+	KeyAlgorithm string  `json:"key_algorithm"`
+	KeySize      int     `json:"key_size"`
+	KeyType      KeyType `json:"key_type,omitempty"`
+
+	// KeyUsage and ExtKeyUsage use the names of the profiles.
+	KeyUsage    []string `json:"key_usage,omitempty"`
+	ExtKeyUsage []string `json:"ext_key_usage,omitempty"`
+
+	// MaxPathLen is the number of intermediate CAs allowed below a CA,
+	// negative when unlimited.
+	IsCA       bool `json:"is_ca"`
+	MaxPathLen int  `json:"max_path_len"`
+	SelfSigned bool `json:"self_signed"`
+
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+
+	// hex encoded fingerprints of the certificate
+	SHA1Fingerprint   string `json:"sha1_fingerprint"`
+	SHA256Fingerprint string `json:"sha256_fingerprint"`
+
+	// SPKISHA256 is the base64 encoded SHA-256 hash of the public key info,
+	// the pin of PinSet.
+	SPKISHA256 string `json:"spki_sha256"`
+}
+
+// SANs returns all the subject alternative names.
+func (s Summary) SANs() []string {
+	var sans []string
+	sans = append(sans, s.DNSNames...)
+	sans = append(sans, s.IPAddresses...)
+	sans = append(sans, s.EmailAddresses...)
+	sans = append(sans, s.URIs...)
+	return sans
+}
+
+// Inspect returns a summary of the certificate.
+func Inspect(cert *x509.Certificate) Summary {
+	s := Summary{
+		Subject:        cert.Subject.String(),
+		Issuer:         cert.Issuer.String(),
+		Serial:         fmt.Sprintf("%X", cert.SerialNumber),
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		KeyAlgorithm:   cert.PublicKeyAlgorithm.String(),
+		IsCA:           cert.IsCA,
+		SelfSigned:     bytes.Equal(cert.RawIssuer, cert.RawSubject),
+		NotBefore:      cert.NotBefore,
+		NotAfter:       cert.NotAfter,
+	}
+
+	for _, ip := range cert.IPAddresses {
+		s.IPAddresses = append(s.IPAddresses, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		s.URIs = append(s.URIs, uri.String())
+	}
+
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		s.KeySize = pub.N.BitLen()
+	case *ecdsa.PublicKey:
+		s.KeySize = pub.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		s.KeySize = 256
+	}
+	s.KeyType, _ = PublicKeyType(cert.PublicKey)
+
+	for name, usage := range keyUsageNames {
+		if cert.KeyUsage&usage != 0 {
+			s.KeyUsage = append(s.KeyUsage, name)
+		}
+	}
+	sort.Strings(s.KeyUsage)
+
+	for _, usage := range cert.ExtKeyUsage {
+		s.ExtKeyUsage = append(s.ExtKeyUsage, extKeyUsageName(usage))
+	}
+
+	if cert.IsCA {
+		s.MaxPathLen = cert.MaxPathLen
+	}
+
+	sha1Sum := sha1.Sum(cert.Raw)
+	sha256Sum := sha256.Sum256(cert.Raw)
+	s.SHA1Fingerprint = fmt.Sprintf("%X", sha1Sum)
+	s.SHA256Fingerprint = fmt.Sprintf("%X", sha256Sum)
+	s.SPKISHA256 = SPKIPin(cert)
+
+	return s
+}
+
+func extKeyUsageName(usage x509.ExtKeyUsage) string {
+	for name, u := range extKeyUsageNames {
+		if u == usage {
+			return name
+		}
+	}
+	return fmt.Sprintf("ext_key_usage(%d)", int(usage))
+}
+
+// VerifyError reports a certificate that failed verification,
+// with the reasons found by checking the chain step by step.
+type VerifyError struct {
+	Reasons []string
+
+	// Err is the error of x509.Certificate.Verify.
+	Err error
+}
+
+func (e *VerifyError) Error() string {
+	return "verify: " + strings.Join(e.Reasons, "; ")
+}
+
+func (e *VerifyError) Unwrap() error {
+	return e.Err
+}
+
+// Verify checks that the leaf certificate chains to the roots through the
+// intermediates, is valid now for the usage and, unless empty, for the
+// host name or IP address. ExtKeyUsageAny accepts all usages. The roots
+// are usually built by NewCertPool. A failure returns a *VerifyError.
+func Verify(leaf *x509.Certificate, intermediates []*x509.Certificate, roots *x509.CertPool,
+	usage x509.ExtKeyUsage, host string) ([][]*x509.Certificate, error) {
+
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+		DNSName:       host,
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	}
+	for _, cert := range intermediates {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	chains, err := leaf.Verify(opts)
+	if err == nil {
+		return chains, nil
+	}
+
+	return nil, &VerifyError{
+		Reasons: verifyReasons(leaf, intermediates, opts, err),
+		Err:     err,
+	}
+}
+
+// verifyReasons explains the failure of x509 verification, which reports
+// only one error: each property is checked on its own.
+func verifyReasons(leaf *x509.Certificate, intermediates []*x509.Certificate,
+	opts x509.VerifyOptions, err error) []string {
+
+	var reasons []string
+	now := time.Now()
+
+	for _, cert := range append([]*x509.Certificate{leaf}, intermediates...) {
+		name := cert.Subject.String()
+		if now.Before(cert.NotBefore) {
+			reasons = append(reasons, fmt.Sprintf("%s is not valid before %v", name, cert.NotBefore))
+		}
+		if now.After(cert.NotAfter) {
+			reasons = append(reasons, fmt.Sprintf("%s expired at %v", name, cert.NotAfter))
+		}
+	}
+
+	if opts.DNSName != "" {
+		if err := leaf.VerifyHostname(opts.DNSName); err != nil {
+			reasons = append(reasons, fmt.Sprintf("%s is not valid for %q, names: %s",
+				leaf.Subject, opts.DNSName, strings.Join(Inspect(leaf).SANs(), ", ")))
+		}
+	}
+
+	if usage := opts.KeyUsages[0]; usage != x509.ExtKeyUsageAny && !hasExtKeyUsage(leaf, usage) {
+		reasons = append(reasons, fmt.Sprintf("%s is not valid for %s, usages: %s",
+			leaf.Subject, extKeyUsageName(usage), strings.Join(Inspect(leaf).ExtKeyUsage, ", ")))
+	}
+
+	// the chain is checked at a time the leaf is valid, for any name and usage
+	chainOpts := x509.VerifyOptions{
+		Roots:         opts.Roots,
+		Intermediates: opts.Intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		CurrentTime:   leaf.NotBefore.Add(leaf.NotAfter.Sub(leaf.NotBefore) / 2),
+	}
+	if _, chainErr := leaf.Verify(chainOpts); chainErr != nil {
+		switch chainErr := chainErr.(type) {
+		case x509.CertificateInvalidError:
+			if chainErr.Reason != x509.Expired {
+				reasons = append(reasons, chainErr.Error())
+			}
+		case x509.UnknownAuthorityError:
+			reasons = append(reasons, fmt.Sprintf("%s is not issued by a trusted CA, issuer: %s",
+				leaf.Subject, leaf.Issuer))
+		default:
+			reasons = append(reasons, chainErr.Error())
+		}
+	}
+
+	if len(reasons) == 0 {
+		reasons = append(reasons, err.Error())
+	}
+
+	return reasons
+}
+
+func hasExtKeyUsage(cert *x509.Certificate, usage x509.ExtKeyUsage) bool {
+	if len(cert.ExtKeyUsage) == 0 {
+		// no extension: all usages are allowed
+		return true
+	}
+	for _, u := range cert.ExtKeyUsage {
+		if u == usage || u == x509.ExtKeyUsageAny {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package cert
+
+import (
+	"crypto/x509"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	leaf, err := ReadCert(pki, "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := Inspect(leaf)
+	if s.KeyType != RSA2048 || s.KeySize != 2048 || s.KeyAlgorithm != "RSA" {
+		t.Fatalf("unexpected key: %s %s %d", s.KeyType, s.KeyAlgorithm, s.KeySize)
+	}
+	if len(s.ExtKeyUsage) != 1 || s.ExtKeyUsage[0] != "server_auth" {
+		t.Fatalf("unexpected ext key usage: %v", s.ExtKeyUsage)
+	}
+	if len(s.DNSNames) == 0 || s.DNSNames[0] != "localhost" {
+		t.Fatalf("unexpected dns names: %v", s.DNSNames)
+	}
+	if s.IsCA || s.SelfSigned || len(s.SHA256Fingerprint) != 64 || s.SPKISHA256 == "" {
+		t.Fatalf("unexpected summary: %+v", s)
+	}
+
+	ca, err := ReadCert(pki, "ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := Inspect(ca); !s.IsCA || !s.SelfSigned {
+		t.Fatalf("unexpected ca summary: %+v", s)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	leaf, err := ReadCert(pki, "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots, err := NewCertPool(pki, "ca", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Verify(leaf, nil, roots, x509.ExtKeyUsageServerAuth, "localhost"); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	store := NewMemStore()
+	if err := CreateCACert(store, "other", profiles.CA); err != nil {
+		t.Fatal(err)
+	}
+	others, err := NewCertPool(store, "other", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		roots *x509.CertPool
+		usage x509.ExtKeyUsage
+		host  string
+	}{
+		{"client usage", roots, x509.ExtKeyUsageClientAuth, ""},
+		{"wrong host", roots, x509.ExtKeyUsageServerAuth, "example.com"},
+		{"foreign ca", others, x509.ExtKeyUsageServerAuth, "localhost"},
+		{"all wrong", others, x509.ExtKeyUsageClientAuth, "example.com"},
+	}
+	for _, tt := range tests {
+		_, err := Verify(leaf, nil, tt.roots, tt.usage, tt.host)
+		verr, ok := err.(*VerifyError)
+		if !ok {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if tt.name == "all wrong" && len(verr.Reasons) != 3 {
+			t.Fatalf("%s: unexpected reasons: %q", tt.name, verr.Reasons)
+		}
+		t.Logf("%s: %v", tt.name, verr)
+	}
+}
@@ -0,0 +1,108 @@
+package cert
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+)
+
+// signCert signs the certificate template with the CA and returns the pem
+// blocks of the certificate followed by the chain of the CA, without the
+// self-signed root.
+func signCert(cert *x509.Certificate, pub crypto.PublicKey, tlsCA tls.Certificate) ([]*pem.Block, error) {
+	der, err := x509.CreateCertificate(
+		rand.Reader,
+		cert,
+		tlsCA.Leaf,
+		pub,
+		tlsCA.PrivateKey,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("encode cert: %v", err)
+	}
+	blocks := []*pem.Block{{Type: "CERTIFICATE", Bytes: der}}
+
+	for _, raw := range tlsCA.Certificate {
+		ca, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return nil, fmt.Errorf("decode ca cert: %v", err)
+		}
+		if bytes.Equal(ca.RawIssuer, ca.RawSubject) {
+			continue
+		}
+		blocks = append(blocks, &pem.Block{Type: "CERTIFICATE", Bytes: raw})
+	}
+
+	return blocks, nil
+}
+
+// saveCert saves the key and the certificate chain of cn to store,
+// and records the certificate in the index. With replace, the existing
+// files are replaced by replaceCert, and the replaced certificate is
+// revoked as superseded.
+func saveCert(store KeyStore, issuer, cn, password string, key crypto.Signer, blocks []*pem.Block, replace bool) error {
+	var err error
+	var old *x509.Certificate
+	if replace {
+		// a missing certificate is issued
+		old, _ = ReadCert(store, cn)
+		err = replaceCert(store, cn, password, key, blocks)
+	} else {
+		err = SaveKey(store, cn, password, key)
+		if err != nil {
+			return fmt.Errorf("save key: %v", err)
+		}
+
+		err = SaveCertBlock(store, cn, blocks...)
+	}
+	if err != nil {
+		return fmt.Errorf("save cert: %v", err)
+	}
+
+	err = recordCert(store, issuer, cn, blocks[0].Bytes)
+	if err != nil {
+		return fmt.Errorf("record cert: %v", err)
+	}
+
+	if old != nil {
+		if err := supersede(store, old.SerialNumber); err != nil {
+			return fmt.Errorf("supersede cert: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// replaceCert writes the key, then the certificate chain of cn. Each file
+// is replaced atomically but not the pair: a reader may get the new key
+// with the old certificate, like CertReloader which keeps the previous pair
+// until both match.
+func replaceCert(store KeyStore, cn, password string, key crypto.Signer, blocks []*pem.Block) error {
+	keyBlock, err := encodeKey(key, password)
+	if err != nil {
+		return err
+	}
+
+	name := cn + ".key"
+	log.Printf("replacing %q key in %q\n", cn, name)
+
+	err = store.WriteFile(name, pem.EncodeToMemory(keyBlock), 0400)
+	if err != nil {
+		return fmt.Errorf("write key: %v", err)
+	}
+
+	var data []byte
+	for _, block := range blocks {
+		data = append(data, pem.EncodeToMemory(block)...)
+	}
+
+	name = cn + ".crt"
+	log.Printf("replacing %q certificate in %q\n", cn, name)
+
+	return store.WriteFile(name, data, 0644)
+}
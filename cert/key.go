@@ -1,22 +1,81 @@
 package cert
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"os"
-	"path/filepath"
+	"strings"
 )
 
-func ReadKey(pkiPath, cn, password string) (*rsa.PrivateKey, error) {
-	path := filepath.Join(pkiPath, cn+".key")
-	log.Printf("reading %q key from %q\n", cn, path)
+// KeyType names a private key algorithm and size.
+type KeyType string
 
-	data, err := ioutil.ReadFile(path)
+const (
+	RSA2048   KeyType = "rsa2048"
+	RSA4096   KeyType = "rsa4096"
+	ECDSAP256 KeyType = "p256"
+	ECDSAP384 KeyType = "p384"
+	Ed25519   KeyType = "ed25519"
+)
+
+// GenerateKey generates a new private key of the given type,
+// an empty type defaults to RSA2048.
+func GenerateKey(keyType KeyType) (crypto.Signer, error) {
+	switch keyType {
+	case "", RSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case RSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case ECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case ECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case Ed25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return nil, fmt.Errorf("unsupported key type: %q", keyType)
+	}
+}
+
+// PublicKeyType returns the type of a public key.
+func PublicKeyType(pub crypto.PublicKey) (KeyType, error) {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		switch pub.N.BitLen() {
+		case 2048:
+			return RSA2048, nil
+		case 4096:
+			return RSA4096, nil
+		}
+		return "", fmt.Errorf("unsupported rsa key size: %d", pub.N.BitLen())
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			return ECDSAP256, nil
+		case elliptic.P384():
+			return ECDSAP384, nil
+		}
+		return "", fmt.Errorf("unsupported curve: %v", pub.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return Ed25519, nil
+	default:
+		return "", fmt.Errorf("unsupported public key: %T", pub)
+	}
+}
+
+func ReadKey(store KeyStore, cn, password string) (crypto.Signer, error) {
+	name := cn + ".key"
+	log.Printf("reading %q key from %q\n", cn, name)
+
+	data, err := store.ReadFile(name)
 	if err != nil {
 		return nil, err
 	}
@@ -26,19 +85,39 @@ func ReadKey(pkiPath, cn, password string) (*rsa.PrivateKey, error) {
 		return nil, fmt.Errorf("no pem data found")
 	}
 
-	if block.Type != "RSA PRIVATE KEY" {
-		return nil, fmt.Errorf("invalid type: %v", block.Type)
+	return decodeKey(block, password)
+}
+
+// decodeKey decrypts the pem block with password if it is encrypted,
+// either as an encrypted PKCS#8 key or with the legacy PEM encryption
+// (RFC 1423), then parses the key.
+func decodeKey(block *pem.Block, password string) (crypto.Signer, error) {
+	blockType, der := block.Type, block.Bytes
+
+	legacy := x509.IsEncryptedPEMBlock(block)
+
+	switch {
+	case blockType == "ENCRYPTED PRIVATE KEY" || legacy:
+		if password == "" {
+			return nil, fmt.Errorf("decrypt key: password required")
+		}
+	case password != "":
+		return nil, fmt.Errorf("decrypt key: key is not encrypted")
 	}
 
-	der := block.Bytes
-	if password != "" {
+	var err error
+	switch {
+	case blockType == "ENCRYPTED PRIVATE KEY":
+		blockType = "PRIVATE KEY"
+		der, err = decryptPKCS8(der, password)
+	case legacy:
 		der, err = x509.DecryptPEMBlock(block, []byte(password))
-		if err != nil {
-			return nil, fmt.Errorf("decrypt key: %v", err)
-		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decrypt key: %v", err)
 	}
 
-	key, err := x509.ParsePKCS1PrivateKey(der)
+	key, err := parseKey(blockType, der)
 	if err != nil {
 		return nil, fmt.Errorf("decode key: %v", err)
 	}
@@ -46,48 +125,111 @@ func ReadKey(pkiPath, cn, password string) (*rsa.PrivateKey, error) {
 	return key, nil
 }
 
-func SaveKey(pkiPath, cn, password string, key *rsa.PrivateKey) error {
+// parseKey detects the key type from the pem block type:
+// PKCS#8, PKCS#1 (RSA) and SEC 1 (EC) encodings are supported.
+func parseKey(blockType string, der []byte) (crypto.Signer, error) {
+	switch blockType {
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported key: %T", key)
+		}
+		return signer, nil
+
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(der)
+
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(der)
+
+	default:
+		return nil, fmt.Errorf("invalid type: %v", blockType)
+	}
+}
+
+func SaveKey(store KeyStore, cn, password string, key crypto.Signer) error {
+	block, err := encodeKey(key, password)
+	if err != nil {
+		return err
+	}
+
+	return SaveKeyBlock(store, cn, block)
+}
+
+// encodeKey encodes the key as PKCS#8,
+// encrypted with password when not empty.
+func encodeKey(key crypto.Signer, password string) (*pem.Block, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("encode key: %v", err)
+	}
+
 	block := pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(key),
+		Type:  "PRIVATE KEY",
+		Bytes: der,
 	}
 	if password == "" {
-		return SaveKeyBlock(pkiPath, cn, &block)
+		return &block, nil
 	}
 
-	encryptedBlock, err := x509.EncryptPEMBlock(
-		rand.Reader,
-		block.Type,
-		block.Bytes,
-		[]byte(password),
-		x509.PEMCipherAES256,
-	)
+	encryptedBlock, err := encryptPKCS8(block.Bytes, password)
 	if err != nil {
-		return fmt.Errorf("encrypt key: %v", err)
+		return nil, fmt.Errorf("encrypt key: %v", err)
 	}
 
-	return SaveKeyBlock(pkiPath, cn, encryptedBlock)
+	return encryptedBlock, nil
 }
 
-func SaveKeyBlock(pkiPath, cn string, block *pem.Block) error {
-	path := filepath.Join(pkiPath, cn+".key")
-	log.Printf("writing %q key to %q\n", cn, path)
+func SaveKeyBlock(store KeyStore, cn string, block *pem.Block) error {
+	name := cn + ".key"
+	log.Printf("writing %q key to %q\n", cn, name)
 
-	// O_EXCL: file must not exist
-	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0400)
-	if err != nil {
-		return err
-	}
+	// the key file must not exist
+	return store.CreateFile(name, pem.EncodeToMemory(block), 0400)
+}
 
-	err = pem.Encode(file, block)
+// MigrateKeys re-encrypts the keys of store that use the legacy PEM
+// encryption as encrypted PKCS#8 keys, password returns the password of
+// the key named cn. It returns the names of the migrated keys.
+func MigrateKeys(store KeyStore, password func(cn string) string) ([]string, error) {
+	names, err := store.List("*.key")
 	if err != nil {
-		return fmt.Errorf("write key: %v", err)
+		return nil, err
 	}
 
-	err = file.Close()
-	if err != nil {
-		return err
+	var migrated []string
+	for _, name := range names {
+		data, err := store.ReadFile(name)
+		if err != nil {
+			return migrated, err
+		}
+
+		block, _ := pem.Decode(data)
+		if block == nil || !x509.IsEncryptedPEMBlock(block) {
+			continue
+		}
+
+		cn := strings.TrimSuffix(name, ".key")
+		key, err := decodeKey(block, password(cn))
+		if err != nil {
+			return migrated, fmt.Errorf("migrate %q key: %v", cn, err)
+		}
+
+		block, err = encodeKey(key, password(cn))
+		if err != nil {
+			return migrated, fmt.Errorf("migrate %q key: %v", cn, err)
+		}
+
+		log.Printf("migrating %q key in %q\n", cn, name)
+		if err := store.WriteFile(name, pem.EncodeToMemory(block), 0400); err != nil {
+			return migrated, err
+		}
+		migrated = append(migrated, cn)
 	}
 
-	return nil
+	return migrated, nil
 }
@@ -0,0 +1,320 @@
+package cert
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// maxOCSPRequestSize limits the size of the OCSP requests.
+const maxOCSPRequestSize = 16 << 10
+
+// ocspRetry delays the next fetch of an OCSP response after a failure.
+const ocspRetry = 30 * time.Second
+
+// OCSPHandler answers the OCSP requests (RFC 6960) about the certificates
+// issued by ca, from the index of store. Requests are accepted with GET
+// or POST, the responses are signed by the CA and valid for the given
+// duration. OCSP signing is not supported with Ed25519 CA keys.
+func OCSPHandler(store KeyStore, ca string, validity time.Duration) http.Handler {
+	return &ocspHandler{
+		store:    store,
+		ca:       ca,
+		validity: validity,
+	}
+}
+
+type ocspHandler struct {
+	store    KeyStore
+	ca       string
+	validity time.Duration
+}
+
+func (h *ocspHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var der []byte
+	var err error
+
+	switch r.Method {
+	case http.MethodGet:
+		var path string
+		path, err = url.PathUnescape(strings.TrimPrefix(r.URL.Path, "/"))
+		if err == nil {
+			der, err = base64.StdEncoding.DecodeString(path)
+		}
+	case http.MethodPost:
+		der, err = ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxOCSPRequestSize))
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		h.writeResponse(w, ocsp.MalformedRequestErrorResponse)
+		return
+	}
+
+	req, err := ocsp.ParseRequest(der)
+	if err != nil {
+		h.writeResponse(w, ocsp.MalformedRequestErrorResponse)
+		return
+	}
+
+	resp, err := h.respond(req)
+	if err != nil {
+		log.Printf("ocsp %X: %v\n", req.SerialNumber, err)
+		h.writeResponse(w, ocsp.InternalErrorErrorResponse)
+		return
+	}
+
+	h.writeResponse(w, resp)
+}
+
+func (h *ocspHandler) writeResponse(w http.ResponseWriter, resp []byte) {
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.Write(resp)
+}
+
+func (h *ocspHandler) respond(req *ocsp.Request) ([]byte, error) {
+	tlsCA, err := ReadTLSCert(h.store, h.ca, h.ca)
+	if err != nil {
+		return nil, fmt.Errorf("read ca cert: %v", err)
+	}
+	issuer := tlsCA.Leaf
+
+	// the request identifies the issuer by the hash of its public key
+	issuerKeyHash, err := ocspIssuerKeyHash(issuer, req.HashAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(issuerKeyHash, req.IssuerKeyHash) {
+		return ocsp.UnauthorizedErrorResponse, nil
+	}
+
+	now := time.Now()
+	template := ocsp.Response{
+		Status:       ocsp.Unknown,
+		SerialNumber: req.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(h.validity),
+	}
+
+	record, err := FindRecord(h.store, req.SerialNumber)
+	if err != nil && err != ErrNotFound {
+		return nil, fmt.Errorf("find record: %v", err)
+	}
+	if err == nil && record.Issuer == h.ca {
+		switch record.Status {
+		case StatusRevoked:
+			template.Status = ocsp.Revoked
+			template.RevokedAt = record.RevokedAt
+			template.RevocationReason = int(record.Reason)
+		default:
+			template.Status = ocsp.Good
+		}
+	}
+
+	signer, ok := tlsCA.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key: %T", tlsCA.PrivateKey)
+	}
+
+	return ocsp.CreateResponse(issuer, issuer, template, signer)
+}
+
+func ocspIssuerKeyHash(issuer *x509.Certificate, hash crypto.Hash) ([]byte, error) {
+	if !hash.Available() {
+		return nil, fmt.Errorf("unsupported hash: %v", hash)
+	}
+
+	// the hash of a dummy request holds the hash of the issuer key
+	der, err := ocsp.CreateRequest(&x509.Certificate{SerialNumber: big.NewInt(1)}, issuer,
+		&ocsp.RequestOptions{Hash: hash})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := ocsp.ParseRequest(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return req.IssuerKeyHash, nil
+}
+
+// WithOCSPStapling staples to the server certificate the OCSP response
+// fetched from the OCSP server of the certificate. The response is fetched
+// in the background when the config is created, the OCSP server may be the
+// server being configured, then refreshed halfway to its next update.
+// The handshakes have no staple until the first response is fetched.
+func WithOCSPStapling() TLSOption {
+	return func(o *tlsOptions) {
+		o.ocsp = true
+	}
+}
+
+// ocspStapler adds an OCSP response to the certificates returned by getCertificate.
+type ocspStapler struct {
+	getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	issuer         *x509.Certificate
+	client         *http.Client
+
+	mu       sync.Mutex
+	serial   *big.Int
+	staple   []byte
+	refresh  time.Time
+	fetching bool
+}
+
+func newOCSPStapler(store KeyStore, ca string, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) (*ocspStapler, error) {
+	issuer, err := ReadCert(store, ca)
+	if err != nil {
+		return nil, fmt.Errorf("read ca cert: %v", err)
+	}
+
+	rootCAs, err := NewCertPool(store, ca, true)
+	if err != nil {
+		return nil, fmt.Errorf("create ca pool: %v", err)
+	}
+
+	s := &ocspStapler{
+		getCertificate: getCertificate,
+		issuer:         issuer,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: rootCAs},
+			},
+		},
+	}
+
+	cert, err := getCertificate(nil)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.serial = cert.Leaf.SerialNumber
+	s.fetching = true
+	s.mu.Unlock()
+	go s.fetch(cert)
+
+	return s, nil
+}
+
+func (s *ocspStapler) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, err := s.getCertificate(hello)
+	if err != nil || cert == nil {
+		return cert, err
+	}
+
+	staple := s.current(cert)
+	if staple == nil {
+		return cert, nil
+	}
+
+	stapled := *cert
+	stapled.OCSPStaple = staple
+	return &stapled, nil
+}
+
+// current returns the OCSP response of cert if any,
+// and starts fetching a new one when it is due.
+func (s *ocspStapler) current(cert *tls.Certificate) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.serial == nil || s.serial.Cmp(cert.Leaf.SerialNumber) != 0 {
+		s.serial = cert.Leaf.SerialNumber
+		s.staple = nil
+		s.refresh = time.Time{}
+	}
+
+	if !s.fetching && time.Now().After(s.refresh) {
+		s.fetching = true
+		go s.fetch(cert)
+	}
+
+	return s.staple
+}
+
+func (s *ocspStapler) fetch(cert *tls.Certificate) {
+	staple, refresh, err := s.request(cert)
+	if err != nil {
+		log.Printf("fetch ocsp response for %X: %v\n", cert.Leaf.SerialNumber, err)
+		refresh = time.Now().Add(ocspRetry)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fetching = false
+	if s.serial.Cmp(cert.Leaf.SerialNumber) != 0 {
+		return
+	}
+	if err == nil {
+		s.staple = staple
+	}
+	s.refresh = refresh
+}
+
+func (s *ocspStapler) request(cert *tls.Certificate) ([]byte, time.Time, error) {
+	leaf := cert.Leaf
+	if len(leaf.OCSPServer) == 0 {
+		return nil, time.Time{}, fmt.Errorf("no ocsp server")
+	}
+
+	issuer := s.issuer
+	if len(cert.Certificate) > 1 {
+		var err error
+		issuer, err = x509.ParseCertificate(cert.Certificate[1])
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("decode issuer cert: %v", err)
+		}
+	}
+
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("create ocsp request: %v", err)
+	}
+
+	res, err := s.client.Post(leaf.OCSPServer[0], "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("ocsp server: %s", res.Status)
+	}
+
+	staple, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	resp, err := ocsp.ParseResponseForCert(staple, leaf, issuer)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("decode ocsp response: %v", err)
+	}
+	log.Printf("fetched ocsp response for %X: status %d, next update %v\n",
+		leaf.SerialNumber, resp.Status, resp.NextUpdate)
+
+	refresh := time.Now().Add(time.Hour)
+	if !resp.NextUpdate.IsZero() {
+		refresh = resp.ThisUpdate.Add(resp.NextUpdate.Sub(resp.ThisUpdate) / 2)
+	}
+
+	return staple, refresh, nil
+}
@@ -0,0 +1,121 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestOCSP(t *testing.T) {
+	store := NewMemStore()
+
+	responder := httptest.NewServer(OCSPHandler(store, "ca", time.Hour))
+	defer responder.Close()
+
+	withOCSP := newProfiles(ECDSAP256)
+	withOCSP.Server.OCSPServer = []string{responder.URL}
+	withOCSP.Client.OCSPServer = []string{responder.URL}
+
+	err := CreateCerts(store, "ca", "localhost", "client", withOCSP)
+	if err != nil {
+		t.Fatalf("create certs: %v", err)
+	}
+
+	issuer, err := ReadCert(store, "ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := ReadCert(store, "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := func(get bool) *ocsp.Response {
+		req, err := ocsp.CreateRequest(client, issuer, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var res *http.Response
+		if get {
+			res, err = http.Get(responder.URL + "/" + base64.StdEncoding.EncodeToString(req))
+		} else {
+			res, err = http.Post(responder.URL, "application/ocsp-request", bytes.NewReader(req))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		data, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := ocsp.ParseResponseForCert(data, client, issuer)
+		if err != nil {
+			t.Fatalf("parse response: %v", err)
+		}
+		return resp
+	}
+
+	if resp := query(true); resp.Status != ocsp.Good {
+		t.Fatalf("unexpected status: %v", resp.Status)
+	}
+
+	if err := Revoke(store, "ca", client.SerialNumber, ReasonSuperseded); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	resp := query(false)
+	if resp.Status != ocsp.Revoked || resp.RevocationReason != ocsp.Superseded {
+		t.Fatalf("unexpected status: %v", resp.Status)
+	}
+
+	// the server staples the response of its certificate
+	var staple []byte
+	withServerPKI(store, func(url string) {
+		client, err := NewTLSClient(store, "ca", "client")
+		if err != nil {
+			t.Fatalf("create client: %v", err)
+		}
+		transport := client.Transport.(*http.Transport)
+		// without SNI, httptest would serve its own certificate
+		transport.TLSClientConfig.ServerName = "localhost"
+		transport.TLSClientConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			staple = cs.OCSPResponse
+			return nil
+		}
+
+		// the first response is fetched in the background
+		for i := 0; staple == nil && i < 50; i++ {
+			if i > 0 {
+				time.Sleep(20 * time.Millisecond)
+			}
+			res, err := client.Get(url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			transport.CloseIdleConnections()
+		}
+	}, WithOCSPStapling())
+
+	server, err := ReadCert(store, "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = ocsp.ParseResponseForCert(staple, server, issuer)
+	if err != nil {
+		t.Fatalf("parse staple: %v", err)
+	}
+	if resp.Status != ocsp.Good {
+		t.Fatalf("unexpected status: %v", resp.Status)
+	}
+}
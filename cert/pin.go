@@ -0,0 +1,103 @@
+package cert
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// PinSet pins the public keys of the servers of NewTLSClient.
+//
+// The pins are base64 encoded SHA-256 hashes of the subject public key info
+// of a certificate, as returned by SPKIPin. A connection is accepted when
+// a pin matches a certificate of the verified chain: the pin of the issuing
+// CA accepts all its certificates, the pin of a leaf survives its renewals
+// with the same key.
+type PinSet struct {
+	// Pins are the hashes of the keys in use.
+	Pins []string
+	// Backups are the hashes of spare keys, not in use yet, which keep
+	// the clients working when a pinned key is lost or compromised.
+	Backups []string
+
+	// ReportOnly accepts the connections without matching pin,
+	// after reporting them.
+	ReportOnly bool
+	// Report is called for each connection without matching pin,
+	// the error is logged when nil.
+	Report func(err *PinError)
+}
+
+// PinError reports a server without matching pin.
+type PinError struct {
+	ServerName string
+	// Chain lists the pins of the verified chain.
+	Chain []string
+}
+
+func (e *PinError) Error() string {
+	return fmt.Sprintf("pin: no pin of %s matches [%s]", e.ServerName, strings.Join(e.Chain, ", "))
+}
+
+// SPKIPin returns the base64 encoded SHA-256 hash of the subject public key
+// info of the certificate.
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// WithPins checks the servers of NewTLSClient against the pin set,
+// in addition to the verification of their chain. It is ignored by
+// NewTLSConfig.
+func WithPins(pins PinSet) TLSOption {
+	return func(o *tlsOptions) {
+		o.pins = &pins
+	}
+}
+
+// verifyConnection returns the tls.Config VerifyConnection checking the pins,
+// also called for resumed sessions.
+func (p *PinSet) verifyConnection() (func(tls.ConnectionState) error, error) {
+	pins := make(map[string]bool)
+	for _, pin := range append(p.Pins, p.Backups...) {
+		sum, err := base64.StdEncoding.DecodeString(pin)
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("invalid pin: %q", pin)
+		}
+		pins[pin] = true
+	}
+	if len(p.Pins) == 0 {
+		return nil, fmt.Errorf("empty pin set")
+	}
+
+	report := p.Report
+	if report == nil {
+		report = func(err *PinError) {
+			log.Printf("%v", err)
+		}
+	}
+
+	return func(cs tls.ConnectionState) error {
+		var chain []string
+		for _, verified := range cs.VerifiedChains {
+			for _, cert := range verified {
+				pin := SPKIPin(cert)
+				if pins[pin] {
+					return nil
+				}
+				chain = append(chain, pin)
+			}
+		}
+
+		err := &PinError{ServerName: cs.ServerName, Chain: chain}
+		report(err)
+		if p.ReportOnly {
+			return nil
+		}
+		return err
+	}, nil
+}
@@ -0,0 +1,82 @@
+package cert
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPins(t *testing.T) {
+	leaf, err := ReadCert(pki, "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := ReadCert(pki, "ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := ReadCert(pki, "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a key not used by the server
+	other := SPKIPin(client)
+
+	withServer(func(url string) {
+		for _, tt := range []struct {
+			name  string
+			pins  PinSet
+			valid bool
+		}{
+			{"leaf", PinSet{Pins: []string{SPKIPin(leaf)}}, true},
+			{"ca", PinSet{Pins: []string{other, SPKIPin(ca)}}, true},
+			{"backup", PinSet{Pins: []string{other}, Backups: []string{SPKIPin(leaf)}}, true},
+			{"mismatch", PinSet{Pins: []string{other}}, false},
+		} {
+			client, err := NewTLSClient(pki, "ca", "client", WithPins(tt.pins))
+			if err != nil {
+				t.Fatalf("%s: create client: %v", tt.name, err)
+			}
+
+			res, err := client.Get(url)
+			if err == nil {
+				res.Body.Close()
+			}
+
+			var pinErr *PinError
+			if tt.valid && err != nil {
+				t.Fatalf("%s: unexpected error: %v", tt.name, err)
+			}
+			if !tt.valid && !errors.As(err, &pinErr) {
+				t.Fatalf("%s: unexpected error: %v", tt.name, err)
+			}
+		}
+
+		var reported []*PinError
+		client, err := NewTLSClient(pki, "ca", "client", WithPins(PinSet{
+			Pins:       []string{other},
+			ReportOnly: true,
+			Report:     func(err *PinError) { reported = append(reported, err) },
+		}))
+		if err != nil {
+			t.Fatalf("create client: %v", err)
+		}
+		res, err := client.Get(url)
+		if err != nil {
+			t.Fatalf("report only: %v", err)
+		}
+		res.Body.Close()
+		if len(reported) != 1 || len(reported[0].Chain) != 2 || reported[0].Chain[0] != SPKIPin(leaf) {
+			t.Fatalf("unexpected reports: %v", reported)
+		}
+	})
+
+	for _, pins := range []PinSet{
+		{},
+		{Pins: []string{"not a pin"}},
+		{Pins: []string{SPKIPin(leaf)}, Backups: []string{"c2hvcnQ="}},
+	} {
+		if _, err := NewTLSClient(pki, "ca", "client", WithPins(pins)); err == nil {
+			t.Fatalf("invalid pins accepted: %v", pins)
+		}
+	}
+}
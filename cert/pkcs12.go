@@ -0,0 +1,76 @@
+package cert
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// ExportPKCS12 encodes the key and the certificate chain of cn into a
+// PKCS#12 file protected by password. The key of store is decrypted with
+// keyPassword. Modern files use AES-256 and SHA-256, legacy files use
+// 3DES and SHA-1 for older Java versions and operating systems.
+func ExportPKCS12(store KeyStore, cn, keyPassword, password string, legacy bool) ([]byte, error) {
+	tlsCert, err := ReadTLSCert(store, cn, keyPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	var caCerts []*x509.Certificate
+	for _, der := range tlsCert.Certificate[1:] {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("decode cert: %v", err)
+		}
+		caCerts = append(caCerts, cert)
+	}
+
+	encoder := pkcs12.Modern
+	if legacy {
+		encoder = pkcs12.Legacy
+	}
+
+	log.Printf("exporting %q key and certificate\n", cn)
+	data, err := encoder.Encode(tlsCert.PrivateKey, tlsCert.Leaf, caCerts, password)
+	if err != nil {
+		return nil, fmt.Errorf("encode pkcs12: %v", err)
+	}
+
+	return data, nil
+}
+
+// ImportPKCS12 decodes a PKCS#12 file protected by password and writes
+// its key and certificate chain as cn in store. The key is encrypted
+// with keyPassword when not empty. The key file must not exist.
+func ImportPKCS12(store KeyStore, cn, keyPassword string, data []byte, password string) error {
+	privateKey, cert, caCerts, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		return fmt.Errorf("decode pkcs12: %v", err)
+	}
+
+	key, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("unsupported key: %T", privateKey)
+	}
+
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(cert.PublicKey) {
+		return fmt.Errorf("private key does not match certificate")
+	}
+
+	blocks := []*pem.Block{{Type: "CERTIFICATE", Bytes: cert.Raw}}
+	for _, caCert := range caCerts {
+		blocks = append(blocks, &pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})
+	}
+
+	log.Printf("importing %q key and certificate\n", cn)
+	if err := SaveKey(store, cn, keyPassword, key); err != nil {
+		return err
+	}
+
+	return SaveCertBlock(store, cn, blocks...)
+}
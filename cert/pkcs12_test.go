@@ -0,0 +1,53 @@
+package cert
+
+import "testing"
+
+func TestPKCS12(t *testing.T) {
+	store := NewMemStore()
+
+	root := profiles.CA
+	root.MaxPathLen = 1
+	if err := CreateCACert(store, "root", root); err != nil {
+		t.Fatalf("create root cert: %v", err)
+	}
+	if err := CreateIntermediateCACert(store, "root", "ca", profiles.CA); err != nil {
+		t.Fatalf("create intermediate cert: %v", err)
+	}
+	if err := CreateServerCert(store, "ca", "localhost", profiles.Server); err != nil {
+		t.Fatalf("create server cert: %v", err)
+	}
+
+	for name, legacy := range map[string]bool{"modern": false, "legacy": true} {
+		data, err := ExportPKCS12(store, "localhost", "", "secret", legacy)
+		if err != nil {
+			t.Fatalf("export pkcs12: %v", err)
+		}
+
+		if err := ImportPKCS12(store, name, "", data, "wrong"); err == nil {
+			t.Fatal("import with a wrong password")
+		}
+
+		if err := ImportPKCS12(store, name, name, data, "secret"); err != nil {
+			t.Fatalf("import pkcs12: %v", err)
+		}
+
+		imported, err := ReadTLSCert(store, name, name)
+		if err != nil {
+			t.Fatalf("read imported cert: %v", err)
+		}
+		original, err := ReadCertChain(store, "localhost")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(imported.Certificate) != len(original) {
+			t.Fatalf("unexpected chain length: %d", len(imported.Certificate))
+		}
+		if !imported.Leaf.Equal(original[0]) {
+			t.Fatal("unexpected leaf")
+		}
+
+		if err := ImportPKCS12(store, name, "", data, "secret"); err == nil {
+			t.Fatal("import over an existing key")
+		}
+	}
+}
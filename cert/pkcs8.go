@@ -0,0 +1,276 @@
+package cert
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypted PKCS#8 keys (RFC 5958) use the PBES2 scheme (RFC 8018):
+// keys are saved with a scrypt derived key (RFC 7914) and AES-256-CBC,
+// which OpenSSL reads since 1.1.0. Keys derived with PBKDF2 and
+// AES-128/192/256-CBC are also read.
+
+var (
+	oidPBES2  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidScrypt = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11591, 4, 11}
+
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+
+	oidAES128CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// scrypt parameters of the saved keys, the defaults of OpenSSL.
+const (
+	scryptN       = 1 << 14
+	scryptR       = 8
+	scryptP       = 1
+	scryptSaltLen = 16
+)
+
+// Limits of the key derivation parameters read from the key files, so a
+// crafted key cannot exhaust the CPU and the memory, 1 GiB for scrypt.
+const (
+	maxScryptN          = 1 << 20
+	maxScryptRP         = 1 << 10
+	maxScryptMemory     = 1 << 30
+	maxPBKDF2Iterations = 10000000
+)
+
+var errDecryptKey = errors.New("incorrect password or corrupted key")
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+type scryptParams struct {
+	Salt                     []byte
+	CostParameter            int
+	BlockSize                int
+	ParallelizationParameter int
+	KeyLength                int `asn1:"optional"`
+}
+
+// encryptPKCS8 encrypts a PKCS#8 encoded key into an ENCRYPTED PRIVATE KEY block.
+func encryptPKCS8(der []byte, password string) (*pem.Block, error) {
+	salt := make([]byte, scryptSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	key, err := scrypt.Key([]byte(password), salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// PKCS#7 padding
+	padding := aes.BlockSize - len(der)%aes.BlockSize
+	data := make([]byte, len(der)+padding)
+	copy(data, der)
+	for i := len(der); i < len(data); i++ {
+		data[i] = byte(padding)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+
+	kdfParams, err := asn1.Marshal(scryptParams{
+		Salt:                     salt,
+		CostParameter:            scryptN,
+		BlockSize:                scryptR,
+		ParallelizationParameter: scryptP,
+		KeyLength:                32,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ivParams, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{
+			Algorithm:  oidScrypt,
+			Parameters: asn1.RawValue{FullBytes: kdfParams},
+		},
+		EncryptionScheme: pkix.AlgorithmIdentifier{
+			Algorithm:  oidAES256CBC,
+			Parameters: asn1.RawValue{FullBytes: ivParams},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidPBES2,
+			Parameters: asn1.RawValue{FullBytes: params},
+		},
+		EncryptedData: data,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &pem.Block{
+		Type:  "ENCRYPTED PRIVATE KEY",
+		Bytes: encrypted,
+	}, nil
+}
+
+// decryptPKCS8 decrypts an ENCRYPTED PRIVATE KEY block into a PKCS#8 encoded key.
+func decryptPKCS8(der []byte, password string) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if rest, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, errors.New("trailing data")
+	}
+
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported encryption: %v", info.Algorithm.Algorithm)
+	}
+
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("decode pbes2 params: %v", err)
+	}
+
+	var keyLen int
+	switch scheme := params.EncryptionScheme.Algorithm; {
+	case scheme.Equal(oidAES128CBC):
+		keyLen = 16
+	case scheme.Equal(oidAES192CBC):
+		keyLen = 24
+	case scheme.Equal(oidAES256CBC):
+		keyLen = 32
+	default:
+		return nil, fmt.Errorf("unsupported cipher: %v", scheme)
+	}
+
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, fmt.Errorf("decode cipher params: %v", err)
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("invalid iv size: %d", len(iv))
+	}
+
+	key, err := deriveKey(params.KeyDerivationFunc, password, keyLen)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data := info.EncryptedData
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errDecryptKey
+	}
+	data = append([]byte(nil), data...)
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(data, data)
+
+	// PKCS#7 padding
+	padding := int(data[len(data)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, errDecryptKey
+	}
+	for _, b := range data[len(data)-padding:] {
+		if int(b) != padding {
+			return nil, errDecryptKey
+		}
+	}
+
+	return data[:len(data)-padding], nil
+}
+
+func deriveKey(kdf pkix.AlgorithmIdentifier, password string, keyLen int) ([]byte, error) {
+	switch {
+	case kdf.Algorithm.Equal(oidScrypt):
+		var params scryptParams
+		if _, err := asn1.Unmarshal(kdf.Parameters.FullBytes, &params); err != nil {
+			return nil, fmt.Errorf("decode scrypt params: %v", err)
+		}
+		if params.KeyLength != 0 && params.KeyLength != keyLen {
+			return nil, fmt.Errorf("invalid key length: %d", params.KeyLength)
+		}
+		n, r, p := params.CostParameter, params.BlockSize, params.ParallelizationParameter
+		if n <= 1 || n > maxScryptN || r <= 0 || p <= 0 || r*p > maxScryptRP || 128*n*r > maxScryptMemory {
+			return nil, fmt.Errorf("unsupported scrypt params: N=%d r=%d p=%d", n, r, p)
+		}
+		return scrypt.Key([]byte(password), params.Salt, params.CostParameter,
+			params.BlockSize, params.ParallelizationParameter, keyLen)
+
+	case kdf.Algorithm.Equal(oidPBKDF2):
+		var params pbkdf2Params
+		if _, err := asn1.Unmarshal(kdf.Parameters.FullBytes, &params); err != nil {
+			return nil, fmt.Errorf("decode pbkdf2 params: %v", err)
+		}
+		if params.KeyLength != 0 && params.KeyLength != keyLen {
+			return nil, fmt.Errorf("invalid key length: %d", params.KeyLength)
+		}
+		if params.IterationCount <= 0 || params.IterationCount > maxPBKDF2Iterations {
+			return nil, fmt.Errorf("unsupported pbkdf2 iteration count: %d", params.IterationCount)
+		}
+
+		var h func() hash.Hash
+		switch prf := params.PRF.Algorithm; {
+		case len(prf) == 0, prf.Equal(oidHMACWithSHA1):
+			h = sha1.New
+		case prf.Equal(oidHMACWithSHA256):
+			h = sha256.New
+		case prf.Equal(oidHMACWithSHA384):
+			h = sha512.New384
+		case prf.Equal(oidHMACWithSHA512):
+			h = sha512.New
+		default:
+			return nil, fmt.Errorf("unsupported prf: %v", prf)
+		}
+		return pbkdf2.Key([]byte(password), params.Salt, params.IterationCount, keyLen, h), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key derivation: %v", kdf.Algorithm)
+	}
+}
@@ -0,0 +1,111 @@
+package cert
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"testing"
+)
+
+func TestEncryptedKey(t *testing.T) {
+	store := NewMemStore()
+
+	key, err := GenerateKey(ECDSAP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SaveKey(store, "modern", "secret", key); err != nil {
+		t.Fatalf("save key: %v", err)
+	}
+	readKeyBlock := func(cn string) *pem.Block {
+		data, err := store.ReadFile(cn + ".key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, _ := pem.Decode(data)
+		return block
+	}
+	if block := readKeyBlock("modern"); block.Type != "ENCRYPTED PRIVATE KEY" {
+		t.Fatalf("unexpected block type: %s", block.Type)
+	}
+
+	// the legacy pem encryption
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := x509.EncryptPEMBlock(rand.Reader, "PRIVATE KEY", der, []byte("secret"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveKeyBlock(store, "legacy", block); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveKey(store, "plain", "", key); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cn := range []string{"modern", "legacy"} {
+		if _, err := ReadKey(store, cn, "wrong"); err == nil {
+			t.Fatalf("read %s key with a wrong password", cn)
+		}
+		if _, err := ReadKey(store, cn, ""); err == nil {
+			t.Fatalf("read %s key without password", cn)
+		}
+		decoded, err := ReadKey(store, cn, "secret")
+		if err != nil {
+			t.Fatalf("read %s key: %v", cn, err)
+		}
+		if !key.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(decoded.Public()) {
+			t.Fatalf("unexpected %s key", cn)
+		}
+	}
+
+	migrated, err := MigrateKeys(store, func(string) string { return "secret" })
+	if err != nil {
+		t.Fatalf("migrate keys: %v", err)
+	}
+	if len(migrated) != 1 || migrated[0] != "legacy" {
+		t.Fatalf("unexpected migrated keys: %v", migrated)
+	}
+	if block := readKeyBlock("legacy"); block.Type != "ENCRYPTED PRIVATE KEY" {
+		t.Fatalf("unexpected block type: %s", block.Type)
+	}
+	if _, err := ReadKey(store, "legacy", "secret"); err != nil {
+		t.Fatalf("read migrated key: %v", err)
+	}
+	if block := readKeyBlock("plain"); block.Type != "PRIVATE KEY" {
+		t.Fatalf("unexpected block type: %s", block.Type)
+	}
+}
+
+func TestDeriveKeyLimits(t *testing.T) {
+	salt := make([]byte, 16)
+	for _, tc := range []struct {
+		oid    asn1.ObjectIdentifier
+		params interface{}
+		ok     bool
+	}{
+		{oidScrypt, scryptParams{Salt: salt, CostParameter: scryptN, BlockSize: scryptR, ParallelizationParameter: scryptP}, true},
+		{oidScrypt, scryptParams{Salt: salt, CostParameter: 1 << 21, BlockSize: 1, ParallelizationParameter: 1}, false},
+		{oidScrypt, scryptParams{Salt: salt, CostParameter: 1 << 14, BlockSize: 1 << 11, ParallelizationParameter: 1}, false},
+		{oidScrypt, scryptParams{Salt: salt, CostParameter: 1 << 14, BlockSize: 1, ParallelizationParameter: 1 << 11}, false},
+		{oidScrypt, scryptParams{Salt: salt, CostParameter: 1 << 20, BlockSize: 1 << 8, ParallelizationParameter: 1}, false},
+		{oidPBKDF2, pbkdf2Params{Salt: salt, IterationCount: 2048}, true},
+		{oidPBKDF2, pbkdf2Params{Salt: salt, IterationCount: 1 << 30}, false},
+		{oidPBKDF2, pbkdf2Params{Salt: salt, IterationCount: -1}, false},
+	} {
+		der, err := asn1.Marshal(tc.params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kdf := pkix.AlgorithmIdentifier{Algorithm: tc.oid, Parameters: asn1.RawValue{FullBytes: der}}
+		if _, err := deriveKey(kdf, "secret", 32); (err == nil) != tc.ok {
+			t.Fatalf("%+v: unexpected error: %v", tc.params, err)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package cert
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Profile describes the content of the certificates created by
+// CreateCACert, CreateServerCert and CreateClientCert.
+//
+// A zero KeyUsage or a nil ExtKeyUsage selects the defaults of the kind of
+// certificate being created. MaxPathLen only applies to CA certificates:
+// zero forbids any intermediate CA and a negative value removes the limit.
+type Profile struct {
+	KeyType  KeyType
+	Validity time.Duration
+
+	Country            []string
+	Organization       []string
+	OrganizationalUnit []string
+	Locality           []string
+	Province           []string
+	StreetAddress      []string
+	PostalCode         []string
+
+	DNSNames       []string
+	IPAddresses    []net.IP
+	EmailAddresses []string
+	URIs           []*url.URL
+
+	KeyUsage    x509.KeyUsage
+	ExtKeyUsage []x509.ExtKeyUsage
+	MaxPathLen  int
+
+	NameConstraints NameConstraints
+
+	// OCSPServer lists the URLs of the OCSP responders of the issuer.
+	OCSPServer []string
+}
+
+// Profiles holds a profile for each kind of certificate.
+type Profiles struct {
+	CA     Profile `yaml:"ca"`
+	Server Profile `yaml:"server"`
+	Client Profile `yaml:"client"`
+}
+
+var keyUsageNames = map[string]x509.KeyUsage{
+	"digital_signature":  x509.KeyUsageDigitalSignature,
+	"content_commitment": x509.KeyUsageContentCommitment,
+	"key_encipherment":   x509.KeyUsageKeyEncipherment,
+	"data_encipherment":  x509.KeyUsageDataEncipherment,
+	"key_agreement":      x509.KeyUsageKeyAgreement,
+	"cert_sign":          x509.KeyUsageCertSign,
+	"crl_sign":           x509.KeyUsageCRLSign,
+	"encipher_only":      x509.KeyUsageEncipherOnly,
+	"decipher_only":      x509.KeyUsageDecipherOnly,
+}
+
+var extKeyUsageNames = map[string]x509.ExtKeyUsage{
+	"any":              x509.ExtKeyUsageAny,
+	"server_auth":      x509.ExtKeyUsageServerAuth,
+	"client_auth":      x509.ExtKeyUsageClientAuth,
+	"code_signing":     x509.ExtKeyUsageCodeSigning,
+	"email_protection": x509.ExtKeyUsageEmailProtection,
+	"time_stamping":    x509.ExtKeyUsageTimeStamping,
+	"ocsp_signing":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// LoadProfiles reads certificate profiles from a YAML or JSON file.
+//
+//	ca:
+//	  organization: [acme]
+//	  validity: 8760h
+//	  name_constraints:
+//	    permitted_dns_domains: [acme.com]
+//	    permitted_ip_ranges: [10.0.0.0/8]
+//	server:
+//	  organization: [acme]
+//	  dns_names: [api.acme.com, "*.api.acme.com"]
+//	  ip_addresses: [10.0.0.1]
+//	  uris: [spiffe://acme.com/api]
+//	  key_type: p256
+//	  validity: 720h
+func LoadProfiles(path string) (Profiles, error) {
+	log.Printf("reading profiles from %q\n", path)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return Profiles{}, err
+	}
+	defer file.Close()
+
+	var profiles Profiles
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err = decoder.Decode(&profiles); err != nil {
+		return Profiles{}, fmt.Errorf("decode profiles: %v", err)
+	}
+
+	return profiles, nil
+}
+
+type profileFile struct {
+	KeyType  KeyType       `yaml:"key_type"`
+	Validity time.Duration `yaml:"validity"`
+
+	Country            []string `yaml:"country"`
+	Organization       []string `yaml:"organization"`
+	OrganizationalUnit []string `yaml:"organizational_unit"`
+	Locality           []string `yaml:"locality"`
+	Province           []string `yaml:"province"`
+	StreetAddress      []string `yaml:"street_address"`
+	PostalCode         []string `yaml:"postal_code"`
+
+	DNSNames       []string `yaml:"dns_names"`
+	IPAddresses    []string `yaml:"ip_addresses"`
+	EmailAddresses []string `yaml:"email_addresses"`
+	URIs           []string `yaml:"uris"`
+
+	KeyUsage    []string `yaml:"key_usage"`
+	ExtKeyUsage []string `yaml:"ext_key_usage"`
+	MaxPathLen  int      `yaml:"max_path_len"`
+
+	NameConstraints nameConstraintsFile `yaml:"name_constraints"`
+
+	OCSPServer []string `yaml:"ocsp_server"`
+}
+
+type nameConstraintsFile struct {
+	Critical bool `yaml:"critical"`
+
+	PermittedDNSDomains []string `yaml:"permitted_dns_domains"`
+	ExcludedDNSDomains  []string `yaml:"excluded_dns_domains"`
+
+	PermittedIPRanges []string `yaml:"permitted_ip_ranges"`
+	ExcludedIPRanges  []string `yaml:"excluded_ip_ranges"`
+
+	PermittedEmailAddresses []string `yaml:"permitted_email_addresses"`
+	ExcludedEmailAddresses  []string `yaml:"excluded_email_addresses"`
+
+	PermittedURIDomains []string `yaml:"permitted_uri_domains"`
+	ExcludedURIDomains  []string `yaml:"excluded_uri_domains"`
+}
+
+func (p *Profile) UnmarshalYAML(value *yaml.Node) error {
+	var f profileFile
+	if err := value.Decode(&f); err != nil {
+		return err
+	}
+
+	profile := Profile{
+		KeyType:            f.KeyType,
+		Validity:           f.Validity,
+		Country:            f.Country,
+		Organization:       f.Organization,
+		OrganizationalUnit: f.OrganizationalUnit,
+		Locality:           f.Locality,
+		Province:           f.Province,
+		StreetAddress:      f.StreetAddress,
+		PostalCode:         f.PostalCode,
+		DNSNames:           f.DNSNames,
+		EmailAddresses:     f.EmailAddresses,
+		MaxPathLen:         f.MaxPathLen,
+		OCSPServer:         f.OCSPServer,
+		NameConstraints: NameConstraints{
+			Critical:                f.NameConstraints.Critical,
+			PermittedDNSDomains:     f.NameConstraints.PermittedDNSDomains,
+			ExcludedDNSDomains:      f.NameConstraints.ExcludedDNSDomains,
+			PermittedEmailAddresses: f.NameConstraints.PermittedEmailAddresses,
+			ExcludedEmailAddresses:  f.NameConstraints.ExcludedEmailAddresses,
+			PermittedURIDomains:     f.NameConstraints.PermittedURIDomains,
+			ExcludedURIDomains:      f.NameConstraints.ExcludedURIDomains,
+		},
+	}
+
+	for _, v := range []struct {
+		ranges  []string
+		profile *[]*net.IPNet
+	}{
+		{f.NameConstraints.PermittedIPRanges, &profile.NameConstraints.PermittedIPRanges},
+		{f.NameConstraints.ExcludedIPRanges, &profile.NameConstraints.ExcludedIPRanges},
+	} {
+		for _, s := range v.ranges {
+			ipNet, err := parseIPRange(s)
+			if err != nil {
+				return err
+			}
+			*v.profile = append(*v.profile, ipNet)
+		}
+	}
+
+	for _, s := range f.IPAddresses {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return fmt.Errorf("invalid ip address: %q", s)
+		}
+		profile.IPAddresses = append(profile.IPAddresses, ip)
+	}
+
+	for _, s := range f.URIs {
+		uri, err := ParseURI(s)
+		if err != nil {
+			return err
+		}
+		profile.URIs = append(profile.URIs, uri)
+	}
+
+	for _, s := range f.KeyUsage {
+		usage, ok := keyUsageNames[s]
+		if !ok {
+			return fmt.Errorf("invalid key usage: %q", s)
+		}
+		profile.KeyUsage |= usage
+	}
+
+	for _, s := range f.ExtKeyUsage {
+		usage, ok := extKeyUsageNames[s]
+		if !ok {
+			return fmt.Errorf("invalid ext key usage: %q", s)
+		}
+		profile.ExtKeyUsage = append(profile.ExtKeyUsage, usage)
+	}
+
+	*p = profile
+	return nil
+}
+
+func (p Profile) subject(cn string) pkix.Name {
+	return pkix.Name{
+		CommonName:         cn,
+		Country:            p.Country,
+		Organization:       p.Organization,
+		OrganizationalUnit: p.OrganizationalUnit,
+		Locality:           p.Locality,
+		Province:           p.Province,
+		StreetAddress:      p.StreetAddress,
+		PostalCode:         p.PostalCode,
+	}
+}
+
+// template returns a certificate template for cn, the caller sets
+// the fields that depend on the kind of certificate.
+func (p Profile) template(cn string) (x509.Certificate, error) {
+	if p.Validity <= 0 {
+		return x509.Certificate{}, fmt.Errorf("invalid validity: %v", p.Validity)
+	}
+
+	// the subject key id of the CAs is computed from their public key by
+	// x509.CreateCertificate, the leaves get the authority key id
+	return x509.Certificate{
+		Subject:     p.subject(cn),
+		NotBefore:   time.Now(),
+		NotAfter:    time.Now().Add(p.Validity),
+		KeyUsage:    p.KeyUsage,
+		ExtKeyUsage: p.ExtKeyUsage,
+		//
+		DNSNames:       p.DNSNames,
+		IPAddresses:    p.IPAddresses,
+		EmailAddresses: p.EmailAddresses,
+		URIs:           p.URIs,
+		OCSPServer:     p.OCSPServer,
+	}, nil
+}
+
+// ParseURI parses an absolute URI for a URI SAN.
+func ParseURI(s string) (*url.URL, error) {
+	uri, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uri: %q", s)
+	}
+	if !uri.IsAbs() || uri.Host == "" {
+		return nil, fmt.Errorf("invalid uri: %q, missing scheme or host", s)
+	}
+	return uri, nil
+}
@@ -0,0 +1,191 @@
+package cert
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "profiles.yaml")
+	err = ioutil.WriteFile(path, []byte(`
+ca: &default
+  organization: [acme]
+  organizational_unit: [platform]
+  country: [FR]
+  key_type: p256
+  validity: 720h
+  max_path_len: 1
+  name_constraints:
+    permitted_dns_domains: [acme.test]
+    permitted_ip_ranges: [127.0.0.0/8]
+    excluded_email_addresses: [root@acme.test]
+server:
+  <<: *default
+  dns_names: [api.acme.test, "*.svc.acme.test"]
+  ip_addresses: [127.0.0.1]
+  key_usage: [digital_signature]
+  max_path_len: 0
+client:
+  organization: [acme]
+  email_addresses: [bob@acme.test]
+  uris: [spiffe://acme.test/ns/default/sa/bob]
+  ext_key_usage: [client_auth, email_protection]
+  key_type: ed25519
+  validity: 1h
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profiles, err := LoadProfiles(path)
+	if err != nil {
+		t.Fatalf("load profiles: %v", err)
+	}
+
+	if profiles.CA.KeyType != ECDSAP256 || profiles.CA.Validity != 720*time.Hour || profiles.CA.MaxPathLen != 1 {
+		t.Fatalf("unexpected ca profile: %+v", profiles.CA)
+	}
+	if !reflect.DeepEqual(profiles.Server.DNSNames, []string{"api.acme.test", "*.svc.acme.test"}) {
+		t.Fatalf("unexpected dns names: %v", profiles.Server.DNSNames)
+	}
+	if profiles.Server.KeyUsage != x509.KeyUsageDigitalSignature {
+		t.Fatalf("unexpected key usage: %v", profiles.Server.KeyUsage)
+	}
+	if !reflect.DeepEqual(profiles.Client.ExtKeyUsage, []x509.ExtKeyUsage{
+		x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageEmailProtection,
+	}) {
+		t.Fatalf("unexpected ext key usage: %v", profiles.Client.ExtKeyUsage)
+	}
+
+	store := NewMemStore()
+	err = CreateCerts(store, "ca", "localhost", "client", profiles)
+	if err != nil {
+		t.Fatalf("create certs: %v", err)
+	}
+
+	tlsServer, err := ReadTLSCert(store, "localhost", "")
+	if err != nil {
+		t.Fatalf("read server cert: %v", err)
+	}
+	leaf := tlsServer.Leaf
+
+	if leaf.Subject.String() != "CN=localhost,OU=platform,O=acme,C=FR" {
+		t.Fatalf("unexpected subject: %v", leaf.Subject)
+	}
+	if len(leaf.IPAddresses) != 1 || !leaf.IPAddresses[0].Equal([]byte{127, 0, 0, 1}) {
+		t.Fatalf("unexpected ip addresses: %v", leaf.IPAddresses)
+	}
+	if !reflect.DeepEqual(leaf.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}) {
+		t.Fatalf("unexpected ext key usage: %v", leaf.ExtKeyUsage)
+	}
+
+	client, err := ReadCert(store, "client")
+	if err != nil {
+		t.Fatalf("read client cert: %v", err)
+	}
+	if len(client.URIs) != 1 || client.URIs[0].String() != "spiffe://acme.test/ns/default/sa/bob" {
+		t.Fatalf("unexpected uris: %v", client.URIs)
+	}
+
+	tlsCA, err := ReadTLSCert(store, "ca", "ca")
+	if err != nil {
+		t.Fatalf("read ca cert: %v", err)
+	}
+	if tlsCA.Leaf.MaxPathLen != 1 {
+		t.Fatalf("unexpected max path len: %v", tlsCA.Leaf.MaxPathLen)
+	}
+	if !reflect.DeepEqual(tlsCA.Leaf.PermittedDNSDomains, []string{"acme.test"}) ||
+		len(tlsCA.Leaf.PermittedIPRanges) != 1 || len(tlsCA.Leaf.ExcludedEmailAddresses) != 1 {
+		t.Fatalf("unexpected name constraints: %v %v %v", tlsCA.Leaf.PermittedDNSDomains,
+			tlsCA.Leaf.PermittedIPRanges, tlsCA.Leaf.ExcludedEmailAddresses)
+	}
+}
+
+func TestLoadProfilesInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, content := range []string{
+		`{"server": {"key_usage": ["sign_everything"]}}`,
+		`{"server": {"ip_addresses": ["localhost"]}}`,
+		`{"client": {"uris": ["acme/bob"]}}`,
+		`{"ca": {"name_constraints": {"permitted_ip_ranges": ["10.0.0.1"]}}}`,
+		`{"proxy": {}}`,
+	} {
+		path := filepath.Join(dir, "profiles.json")
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadProfiles(path); err == nil {
+			t.Fatalf("expected error loading %s", content)
+		}
+	}
+}
+
+func TestServerNames(t *testing.T) {
+	store := NewMemStore()
+	if err := CreateCACert(store, "ca", profiles.CA); err != nil {
+		t.Fatalf("create ca cert: %v", err)
+	}
+
+	// the name is the only SAN when the profile has none, never looked up
+	profile := profiles.Server
+	profile.DNSNames = nil
+	profile.IPAddresses = nil
+
+	for name, want := range map[string]string{
+		"10.0.0.1":            "10.0.0.1",
+		"api.acme.test":       "api.acme.test",
+		"*.svc.cluster.local": "*.svc.cluster.local",
+	} {
+		if err := CreateServerCert(store, "ca", name, profile); err != nil {
+			t.Fatalf("create server cert: %v", err)
+		}
+		leaf, err := ReadCert(store, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sans := Inspect(leaf).SANs(); len(sans) != 1 || sans[0] != want {
+			t.Fatalf("unexpected sans of %s: %v", name, sans)
+		}
+	}
+
+	uri, err := ParseURI("spiffe://cluster.local/ns/default/sa/api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile.DNSNames = []string{"api.acme.test", "*.api.acme.test"}
+	profile.URIs = []*url.URL{uri}
+	if err := CreateServerCert(store, "ca", "api", profile); err != nil {
+		t.Fatalf("create server cert: %v", err)
+	}
+	leaf, err := ReadCert(store, "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(Inspect(leaf).SANs(), []string{
+		"api.acme.test", "*.api.acme.test", "spiffe://cluster.local/ns/default/sa/api",
+	}) {
+		t.Fatalf("unexpected sans: %v", Inspect(leaf).SANs())
+	}
+	for _, host := range []string{"api.acme.test", "www.api.acme.test"} {
+		if err := leaf.VerifyHostname(host); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package cert
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// The headers set by IdentityProxy from the verified client certificate.
+const (
+	HeaderClientSubject     = "X-Client-Subject"
+	HeaderClientEmail       = "X-Client-Email"
+	HeaderClientURI         = "X-Client-URI"
+	HeaderClientFingerprint = "X-Client-Fingerprint"
+)
+
+var clientHeaders = []string{
+	HeaderClientSubject,
+	HeaderClientEmail,
+	HeaderClientURI,
+	HeaderClientFingerprint,
+}
+
+// IdentityProxy forwards the requests to the upstream, usually a plain HTTP
+// service, with the identity of the verified client certificate in the
+// X-Client-* headers: the subject, one header per email address and URI,
+// and the hex encoded SHA-256 fingerprint.
+//
+// The X-Client-* headers sent by the clients are always removed, as well as
+// their variants with underscores, which some frameworks read as dashes, so
+// the upstream can trust them when it is only reachable through the proxy.
+// The requests without client certificate are forwarded without identity,
+// unless the TLS config requires one.
+//
+// The WriteTimeout of NewTLSServer also limits the responses of the
+// upstream, the long or streamed ones need a StreamingHandler.
+func IdentityProxy(upstream *url.URL) http.Handler {
+	return &httputil.ReverseProxy{
+		// the hop-by-hop headers are removed before Rewrite,
+		// the clients cannot remove the headers set here
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(upstream)
+			r.SetXForwarded()
+
+			for name := range r.Out.Header {
+				if isClientHeader(name) {
+					delete(r.Out.Header, name)
+				}
+			}
+
+			if r.In.TLS == nil || len(r.In.TLS.VerifiedChains) == 0 {
+				return
+			}
+			leaf := r.In.TLS.VerifiedChains[0][0]
+			s := Inspect(leaf)
+
+			r.Out.Header.Set(HeaderClientSubject, s.Subject)
+			for _, email := range s.EmailAddresses {
+				r.Out.Header.Add(HeaderClientEmail, email)
+			}
+			for _, uri := range s.URIs {
+				r.Out.Header.Add(HeaderClientURI, uri)
+			}
+			r.Out.Header.Set(HeaderClientFingerprint, s.SHA256Fingerprint)
+		},
+	}
+}
+
+// isClientHeader reports whether name is one of the X-Client-* headers,
+// with underscores read as dashes.
+func isClientHeader(name string) bool {
+	name = http.CanonicalHeaderKey(strings.ReplaceAll(name, "_", "-"))
+	for _, header := range clientHeaders {
+		if name == header {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package cert
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestIdentityProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(r.Header)
+	}))
+	defer upstream.Close()
+
+	upstreamURL, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := ReadCert(pki, "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withHandler(pki, IdentityProxy(upstreamURL), func(url string) {
+		client, err := NewTLSClient(pki, "ca", "client")
+		if err != nil {
+			t.Fatalf("create client: %v", err)
+		}
+
+		for _, withCert := range []bool{true, false} {
+			if !withCert {
+				client.CloseIdleConnections()
+				client.Transport.(*http.Transport).TLSClientConfig.Certificates = nil
+			}
+
+			req, err := http.NewRequest(http.MethodGet, url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("X-Client-Email", "admin@washingmachine")
+			req.Header.Set("X-Client-Subject", "CN=admin")
+			req.Header.Set("Connection", "X-Client-Fingerprint")
+			req.Header["X-Client_Email"] = []string{"admin@washingmachine"}
+			req.Header["x_client_subject"] = []string{"CN=admin"}
+
+			res, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var header http.Header
+			err = json.NewDecoder(res.Body).Decode(&header)
+			res.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := http.Header{}
+			if withCert {
+				want = http.Header{
+					HeaderClientSubject:     {leaf.Subject.String()},
+					HeaderClientEmail:       {"client@washingmachine"},
+					HeaderClientFingerprint: {Inspect(leaf).SHA256Fingerprint},
+				}
+			}
+			for _, name := range clientHeaders {
+				if !reflect.DeepEqual(header[name], want[name]) {
+					t.Fatalf("with cert %t: unexpected %s: %q", withCert, name, header[name])
+				}
+			}
+			for name := range header {
+				if isClientHeader(name) && want[name] == nil {
+					t.Fatalf("with cert %t: unexpected header %s", withCert, name)
+				}
+			}
+			if header.Get("X-Forwarded-Proto") != "https" {
+				t.Fatalf("unexpected forwarded headers: %v", header)
+			}
+		}
+	})
+}
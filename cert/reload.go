@@ -0,0 +1,179 @@
+package cert
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// CertReloader serves a certificate and a CA pool read from store,
+// and reloads them when the files change in the store. The files are polled
+// during the TLS handshakes, at most once per interval.
+type CertReloader struct {
+	store    KeyStore
+	ca       string
+	cn       string
+	interval time.Duration
+
+	mu      sync.Mutex
+	checked time.Time
+	stamps  map[string]fileStamp
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewCertReloader loads the cn keypair and the ca pool from store.
+func NewCertReloader(store KeyStore, ca, cn string, interval time.Duration) (*CertReloader, error) {
+	r := &CertReloader{
+		store:    store,
+		ca:       ca,
+		cn:       cn,
+		interval: interval,
+	}
+
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *CertReloader) names() []string {
+	return []string{r.cn + ".crt", r.cn + ".key", r.ca + ".crt"}
+}
+
+func (r *CertReloader) stat() (map[string]fileStamp, error) {
+	stamps := make(map[string]fileStamp)
+	for _, name := range r.names() {
+		info, err := r.store.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		stamps[name] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+	}
+	return stamps, nil
+}
+
+// Reload reads the keypair and the CA pool from store.
+func (r *CertReloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.reload()
+}
+
+func (r *CertReloader) reload() error {
+	r.checked = time.Now()
+
+	stamps, err := r.stat()
+	if err != nil {
+		return err
+	}
+
+	cert, err := ReadTLSCert(r.store, r.cn, "")
+	if err != nil {
+		return fmt.Errorf("read cert: %v", err)
+	}
+
+	// the key and the certificate may be replaced one after the other
+	key, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("unsupported key: %T", cert.PrivateKey)
+	}
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(cert.Leaf.PublicKey) {
+		return fmt.Errorf("private key does not match %q certificate", r.cn)
+	}
+
+	pool, err := NewCertPool(r.store, r.ca, false)
+	if err != nil {
+		return fmt.Errorf("create ca pool: %v", err)
+	}
+
+	log.Printf("loaded %q certificate %X, expires %v\n",
+		r.cn, cert.Leaf.SerialNumber, cert.Leaf.NotAfter)
+
+	r.stamps = stamps
+	r.cert = &cert
+	r.pool = pool
+	return nil
+}
+
+// maybeReload reloads the files when they changed since the last check,
+// the current certificate is kept when the reload fails.
+func (r *CertReloader) maybeReload() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checked) < r.interval {
+		return
+	}
+	r.checked = time.Now()
+
+	stamps, err := r.stat()
+	if err != nil {
+		log.Printf("reload %q certificate: %v\n", r.cn, err)
+		return
+	}
+
+	changed := false
+	for name, stamp := range stamps {
+		old := r.stamps[name]
+		if !stamp.modTime.Equal(old.modTime) || stamp.size != old.size {
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+
+	if err := r.reload(); err != nil {
+		log.Printf("reload %q certificate: %v\n", r.cn, err)
+	}
+}
+
+func (r *CertReloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.maybeReload()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cert, r.pool
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, _ := r.current()
+	return cert, nil
+}
+
+// GetClientCertificate implements tls.Config.GetClientCertificate.
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	cert, _ := r.current()
+	return cert, nil
+}
+
+// CertPool returns the current CA pool.
+func (r *CertReloader) CertPool() *x509.CertPool {
+	_, pool := r.current()
+	return pool
+}
+
+// Leaf returns the current certificate.
+func (r *CertReloader) Leaf() *x509.Certificate {
+	cert, _ := r.current()
+	return cert.Leaf
+}
+
+// NotAfter returns the expiry of the current certificate.
+func (r *CertReloader) NotAfter() time.Time {
+	return r.Leaf().NotAfter
+}
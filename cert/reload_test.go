@@ -0,0 +1,81 @@
+package cert
+
+import (
+	"crypto/tls"
+	"net/http"
+	"testing"
+)
+
+func TestCertReloader(t *testing.T) {
+	store := NewMemStore()
+
+	err := CreateCerts(store, "ca", "localhost", "client", profiles)
+	if err != nil {
+		t.Fatalf("create certs: %v", err)
+	}
+
+	reloader, err := NewCertReloader(store, "ca", "localhost", 0)
+	if err != nil {
+		t.Fatalf("create reloader: %v", err)
+	}
+	old := reloader.Leaf()
+
+	// issue a new server cert in the same pki, then move it in place
+	next := NewMemStore()
+	move := func(from, to KeyStore, name string) {
+		data, err := from.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := to.WriteFile(name, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	move(store, next, "ca.crt")
+	move(store, next, "ca.key")
+	server := profiles.Server
+	server.Validity = 2 * validity
+	if err := CreateServerCert(next, "ca", "localhost", server); err != nil {
+		t.Fatalf("create server cert: %v", err)
+	}
+
+	// a key that does not match the certificate is not loaded
+	move(next, store, "localhost.crt")
+	if reloader.Leaf().SerialNumber.Cmp(old.SerialNumber) != 0 {
+		t.Fatal("unexpected reload of mismatched keypair")
+	}
+
+	move(next, store, "localhost.key")
+
+	cert, err := reloader.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("get certificate: %v", err)
+	}
+	if cert.Leaf.SerialNumber.Cmp(old.SerialNumber) == 0 {
+		t.Fatal("certificate not reloaded")
+	}
+	if !reloader.NotAfter().After(old.NotAfter.Add(validity / 2)) {
+		t.Fatalf("unexpected expiry: %v", reloader.NotAfter())
+	}
+
+	withServerPKI(store, func(url string) {
+		client, err := NewTLSClient(store, "ca", "client")
+		if err != nil {
+			t.Fatalf("create client: %v", err)
+		}
+		transport := client.Transport.(*http.Transport)
+		transport.TLSClientConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			if cs.PeerCertificates[0].SerialNumber.Cmp(cert.Leaf.SerialNumber) != 0 {
+				t.Errorf("unexpected server cert: %X", cs.PeerCertificates[0].SerialNumber)
+			}
+			return nil
+		}
+
+		res, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}, WithReloader(reloader))
+
+}
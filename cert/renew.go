@@ -0,0 +1,193 @@
+package cert
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// LeafKind is the kind of a leaf certificate.
+type LeafKind string
+
+const (
+	ServerLeaf LeafKind = "server"
+	ClientLeaf LeafKind = "client"
+)
+
+// LeafKindOf returns the kind of a leaf certificate from its extended key
+// usages: ServerLeaf with server auth, ClientLeaf with client auth only.
+// The CA certificates and the other leaves are refused.
+func LeafKindOf(cert *x509.Certificate) (LeafKind, error) {
+	if cert.IsCA {
+		return "", fmt.Errorf("%s is a CA certificate", cert.Subject)
+	}
+
+	var client bool
+	for _, usage := range cert.ExtKeyUsage {
+		switch usage {
+		case x509.ExtKeyUsageServerAuth:
+			return ServerLeaf, nil
+		case x509.ExtKeyUsageClientAuth:
+			client = true
+		}
+	}
+	if !client {
+		return "", fmt.Errorf("%s is neither a server nor a client certificate", cert.Subject)
+	}
+	return ClientLeaf, nil
+}
+
+// checkReplace refuses to replace the certificate of cn by a leaf of
+// another kind, a missing certificate is issued.
+func checkReplace(store KeyStore, cn string, kind LeafKind) error {
+	old, err := ReadCert(store, cn)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read cert: %v", err)
+	}
+
+	oldKind, err := LeafKindOf(old)
+	if err != nil {
+		return fmt.Errorf("cannot renew %q: %v", cn, err)
+	}
+	if oldKind != kind {
+		return fmt.Errorf("cannot renew %q: %s certificate renewed as %s", cn, oldKind, kind)
+	}
+	return nil
+}
+
+// Leaf defines a certificate issued by the CA of a Renewer.
+type Leaf struct {
+	Kind    LeafKind
+	Name    string
+	Profile Profile
+}
+
+// DefaultRenewThreshold renews certificates after two thirds of their lifetime.
+const DefaultRenewThreshold = 2.0 / 3.0
+
+// Renewer re-issues the leaf certificates of a CA when a fraction of their
+// lifetime has elapsed, missing certificates are issued. The key and the
+// certificate files are each atomically replaced in store, one after the
+// other.
+type Renewer struct {
+	store     KeyStore
+	ca        string
+	leaves    []Leaf
+	threshold float64
+
+	mu          sync.Mutex
+	subscribers []func(Leaf, *x509.Certificate)
+}
+
+// NewRenewer creates a Renewer, a threshold out of (0, 1] defaults
+// to DefaultRenewThreshold.
+func NewRenewer(store KeyStore, ca string, leaves []Leaf, threshold float64) *Renewer {
+	if threshold <= 0 || threshold > 1 {
+		threshold = DefaultRenewThreshold
+	}
+
+	return &Renewer{
+		store:     store,
+		ca:        ca,
+		leaves:    leaves,
+		threshold: threshold,
+	}
+}
+
+// Subscribe registers fn to be called after each renewal.
+//
+//	renewer.Subscribe(func(cert.Leaf, *x509.Certificate) {
+//		reloader.Reload()
+//	})
+func (r *Renewer) Subscribe(fn func(Leaf, *x509.Certificate)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.subscribers = append(r.subscribers, fn)
+}
+
+// Due reports whether the certificate must be renewed at t.
+func (r *Renewer) Due(cert *x509.Certificate, t time.Time) bool {
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	elapsed := t.Sub(cert.NotBefore)
+	return float64(elapsed) >= r.threshold*float64(lifetime)
+}
+
+// Check renews the certificates that are due,
+// it returns the first error but checks all the leaves.
+func (r *Renewer) Check() error {
+	var firstErr error
+
+	for _, leaf := range r.leaves {
+		if err := r.check(leaf); err != nil {
+			log.Printf("renew %q certificate: %v\n", leaf.Name, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("renew %q certificate: %v", leaf.Name, err)
+			}
+		}
+	}
+
+	return firstErr
+}
+
+func (r *Renewer) check(leaf Leaf) error {
+	cert, err := ReadCert(r.store, leaf.Name)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if cert != nil && !r.Due(cert, time.Now()) {
+		return nil
+	}
+
+	switch leaf.Kind {
+	case ServerLeaf:
+		err = RenewServerCert(r.store, r.ca, leaf.Name, leaf.Profile)
+	case ClientLeaf:
+		err = RenewClientCert(r.store, r.ca, leaf.Name, leaf.Profile)
+	default:
+		err = fmt.Errorf("invalid kind: %q", leaf.Kind)
+	}
+	if err != nil {
+		return err
+	}
+
+	cert, err = ReadCert(r.store, leaf.Name)
+	if err != nil {
+		return err
+	}
+	log.Printf("renewed %q certificate %X, expires %v\n",
+		leaf.Name, cert.SerialNumber, cert.NotAfter)
+
+	r.mu.Lock()
+	subscribers := r.subscribers
+	r.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(leaf, cert)
+	}
+
+	return nil
+}
+
+// Run checks the certificates every interval until the context is done.
+func (r *Renewer) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		r.Check()
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func TestRenewer(t *testing.T) {
+	store := NewMemStore()
+
+	err := CreateCerts(store, "ca", "localhost", "client", profiles)
+	if err != nil {
+		t.Fatalf("create certs: %v", err)
+	}
+	old, err := ReadCert(store, "localhost")
+	if err != nil {
+		t.Fatalf("read server cert: %v", err)
+	}
+
+	reloader, err := NewCertReloader(store, "ca", "localhost", time.Hour)
+	if err != nil {
+		t.Fatalf("create reloader: %v", err)
+	}
+
+	leaves := []Leaf{
+		{Kind: ServerLeaf, Name: "localhost", Profile: profiles.Server},
+		{Kind: ClientLeaf, Name: "www", Profile: profiles.Client},
+	}
+
+	// nothing is due with the default threshold, but the missing client
+	renewed := make(map[string]*x509.Certificate)
+	renewer := NewRenewer(store, "ca", leaves, 0)
+	renewer.Subscribe(func(leaf Leaf, cert *x509.Certificate) {
+		renewed[leaf.Name] = cert
+		if err := reloader.Reload(); err != nil {
+			t.Errorf("reload: %v", err)
+		}
+	})
+
+	if err := renewer.Check(); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if len(renewed) != 1 || renewed["www"] == nil {
+		t.Fatalf("unexpected renewals: %v", renewed)
+	}
+	if reloader.Leaf().SerialNumber.Cmp(old.SerialNumber) != 0 {
+		t.Fatal("unexpected server cert renewal")
+	}
+
+	// everything is due
+	renewed = make(map[string]*x509.Certificate)
+	renewer = NewRenewer(store, "ca", leaves, 1e-9)
+	renewer.Subscribe(func(leaf Leaf, cert *x509.Certificate) {
+		renewed[leaf.Name] = cert
+		if err := reloader.Reload(); err != nil {
+			t.Errorf("reload: %v", err)
+		}
+	})
+
+	if err := renewer.Check(); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if len(renewed) != 2 {
+		t.Fatalf("unexpected renewals: %v", renewed)
+	}
+	if renewed["localhost"].SerialNumber.Cmp(old.SerialNumber) == 0 {
+		t.Fatal("server cert not renewed")
+	}
+	if reloader.Leaf().SerialNumber.Cmp(renewed["localhost"].SerialNumber) != 0 {
+		t.Fatal("server cert not reloaded")
+	}
+
+	records, err := FindRecords(store, func(r Record) bool {
+		return r.Name == "localhost"
+	})
+	if err != nil {
+		t.Fatalf("find records: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("unexpected number of records: %d", len(records))
+	}
+
+	// the renewed certificate is superseded
+	if r := records[0]; r.Status != StatusRevoked || r.Reason != ReasonSuperseded || r.Serial.Cmp(old.SerialNumber) != 0 {
+		t.Fatalf("unexpected old record: %v", r)
+	}
+	if r := records[1]; r.Status != StatusValid {
+		t.Fatalf("unexpected new record: %v", r)
+	}
+}
+
+func TestRenewKind(t *testing.T) {
+	store := NewMemStore()
+
+	err := CreateCerts(store, "ca", "localhost", "client", profiles)
+	if err != nil {
+		t.Fatalf("create certs: %v", err)
+	}
+
+	files := []string{"ca.crt", "ca.key", "client.crt", "client.key", indexFile}
+	before := make(map[string][]byte)
+	for _, name := range files {
+		if before[name], err = store.ReadFile(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the CA and the leaves of the other kind are not replaced
+	for _, renew := range []struct {
+		name string
+		fn   func(KeyStore, string, string, Profile) error
+	}{
+		{"ca", RenewClientCert},
+		{"ca", RenewServerCert},
+		{"client", RenewServerCert},
+	} {
+		if err := renew.fn(store, "ca", renew.name, profiles.Client); err == nil {
+			t.Fatalf("%s renewed", renew.name)
+		}
+	}
+
+	for _, name := range files {
+		data, err := store.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, before[name]) {
+			t.Errorf("%s changed", name)
+		}
+	}
+}
@@ -1,71 +1,85 @@
 package cert
 
 import (
-	"crypto/rand"
+	"crypto"
 	"crypto/rsa"
-	"crypto/sha1"
 	"crypto/x509"
-	"crypto/x509/pkix"
-	"encoding/pem"
 	"fmt"
-	"math/big"
 	"net"
-	"time"
 )
 
-func CreateServerCert(pkiPath, ca, server string, validity time.Duration) error {
-	tlsCA, err := ReadTLSCert(pkiPath, ca, ca)
+func CreateServerCert(store KeyStore, ca, server string, profile Profile) error {
+	return createServerCert(store, ca, server, profile, false)
+}
+
+// RenewServerCert issues a new server certificate and replaces the existing
+// key and certificate files, each one atomically.
+func RenewServerCert(store KeyStore, ca, server string, profile Profile) error {
+	return createServerCert(store, ca, server, profile, true)
+}
+
+func createServerCert(store KeyStore, ca, server string, profile Profile, replace bool) error {
+	if replace {
+		if err := checkReplace(store, server, ServerLeaf); err != nil {
+			return err
+		}
+	}
+
+	cert, err := profile.template(server)
+	if err != nil {
+		return fmt.Errorf("create template: %v", err)
+	}
+
+	tlsCA, err := ReadTLSCert(store, ca, ca)
 	if err != nil {
 		return fmt.Errorf("read ca cert: %v", err)
 	}
 
-	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	key, err := GenerateKey(profile.KeyType)
 	if err != nil {
 		return fmt.Errorf("generate key: %v", err)
 	}
 
-	subjectKeyId := sha1.Sum([]byte("CN=" + server + ",O=washingmachine,ST=france,C=EU"))
-	ipAddresses, _ := net.LookupIP(server)
-
-	cert := x509.Certificate{
-		SerialNumber: big.NewInt(11000),
-		SubjectKeyId: subjectKeyId[:],
-		Subject: pkix.Name{
-			CommonName:   server,
-			Organization: []string{"washingmachine"},
-			Province:     []string{"france"},
-			Country:      []string{"EU"},
-		},
-		NotBefore:   time.Now(),
-		NotAfter:    time.Now().Add(validity),
-		KeyUsage:    x509.KeyUsageKeyAgreement | x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-		//
-		DNSNames:    []string{server},
-		IPAddresses: ipAddresses,
+	cert.SerialNumber, err = newSerialNumber()
+	if err != nil {
+		return fmt.Errorf("generate serial number: %v", err)
+	}
+	setServerUsage(&cert, key.Public())
+	if len(cert.DNSNames) == 0 && len(cert.IPAddresses) == 0 {
+		cert.DNSNames, cert.IPAddresses = serverNames(server)
 	}
 
-	der, err := x509.CreateCertificate(
-		rand.Reader,
-		&cert,
-		tlsCA.Leaf,
-		&key.PublicKey,
-		tlsCA.PrivateKey,
-	)
-	if err != nil {
-		return fmt.Errorf("encode cert: %v", err)
+	if err := checkIssuerConstraints(store, ca, &cert); err != nil {
+		return err
 	}
-	block := pem.Block{Type: "CERTIFICATE", Bytes: der}
 
-	err = SaveKey(pkiPath, server, "", key)
+	blocks, err := signCert(&cert, key.Public(), tlsCA)
 	if err != nil {
-		return fmt.Errorf("save key: %v", err)
+		return err
 	}
 
-	err = SaveCertBlock(pkiPath, server, &block)
-	if err != nil {
-		return fmt.Errorf("save cert: %v", err)
+	return saveCert(store, ca, server, "", key, blocks, replace)
+}
+
+// serverNames returns the SAN of a server certificate named cn
+// without DNS names and IP addresses.
+func serverNames(cn string) ([]string, []net.IP) {
+	if ip := net.ParseIP(cn); ip != nil {
+		return nil, []net.IP{ip}
 	}
+	return []string{cn}, nil
+}
 
-	return nil
+// setServerUsage sets the default key usages of server certificates.
+func setServerUsage(cert *x509.Certificate, pub crypto.PublicKey) {
+	if cert.KeyUsage == 0 {
+		// key encipherment only applies to RSA key exchange
+		cert.KeyUsage = x509.KeyUsageDigitalSignature
+		if _, ok := pub.(*rsa.PublicKey); ok {
+			cert.KeyUsage |= x509.KeyUsageKeyAgreement | x509.KeyUsageKeyEncipherment
+		}
+	}
+	if cert.ExtKeyUsage == nil {
+		cert.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
 }
@@ -0,0 +1,168 @@
+package sse
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrClosed is returned by Hub.Subscribe after Close, and by
+// Client.Subscribe when the server answers 204 No Content, like a closed Hub.
+var ErrClosed = errors.New("sse: closed")
+
+// Client reads an event stream, reconnecting and resuming after the errors
+// of the connection, like the EventSource of the browsers.
+type Client struct {
+	// HTTPClient sends the requests, http.DefaultClient when nil.
+	// Its Timeout is ignored.
+	HTTPClient *http.Client
+	// URL is the url of the event stream.
+	URL string
+	// LastEventID is the id of the last received event, sent in the
+	// Last-Event-ID header of the requests.
+	LastEventID string
+	// Retry is the delay before reconnecting, 3 seconds when zero.
+	// It is updated by the retry field of the server.
+	Retry time.Duration
+}
+
+// Subscribe calls fn with the events of the stream until ctx is done,
+// fn returns an error or the server refuses the request.
+func (c *Client) Subscribe(ctx context.Context, fn func(Event) error) error {
+	client := http.DefaultClient
+	if c.HTTPClient != nil {
+		client = c.HTTPClient
+	}
+	// the deadline of the client would end the stream
+	streamClient := *client
+	streamClient.Timeout = 0
+
+	if c.Retry == 0 {
+		c.Retry = 3 * time.Second
+	}
+
+	for {
+		err := c.read(ctx, &streamClient, fn)
+		if err, ok := err.(*fatalError); ok {
+			return err.err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		timer := time.NewTimer(c.Retry)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// fatalError stops the reconnections.
+type fatalError struct {
+	err error
+}
+
+func (e *fatalError) Error() string {
+	return e.err.Error()
+}
+
+// read reads the events of one connection.
+func (c *Client) read(ctx context.Context, client *http.Client, fn func(Event) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL, nil)
+	if err != nil {
+		return &fatalError{fmt.Errorf("create sse request: %v", err)}
+	}
+	req.Header.Set("Accept", ContentType)
+	req.Header.Set("Cache-Control", "no-cache")
+	if c.LastEventID != "" {
+		req.Header.Set("Last-Event-ID", c.LastEventID)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		return &fatalError{ErrClosed}
+	default:
+		data, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return &fatalError{fmt.Errorf("sse: %s: %s", res.Status, data)}
+	}
+	if mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type")); mediaType != ContentType {
+		return &fatalError{fmt.Errorf("sse: invalid content type: %q", res.Header.Get("Content-Type"))}
+	}
+
+	r := bufio.NewReader(res.Body)
+
+	var event string
+	var data strings.Builder
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			// the pending event is discarded
+			return err
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+
+		if line == "" {
+			// dispatch
+			if data.Len() > 0 {
+				e := Event{
+					ID:    c.LastEventID,
+					Event: event,
+					Data:  strings.TrimSuffix(data.String(), "\n"),
+				}
+				if e.Event == "" {
+					e.Event = "message"
+				}
+				if err := fn(e); err != nil {
+					return &fatalError{err}
+				}
+			}
+			event = ""
+			data.Reset()
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			// comment
+			continue
+		}
+
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+
+		switch field {
+		case "event":
+			event = value
+		case "data":
+			data.WriteString(value)
+			data.WriteString("\n")
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				c.LastEventID = value
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(value, 10, 32); err == nil {
+				c.Retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
@@ -0,0 +1,301 @@
+// Package sse serves and consumes Server-Sent Events.
+package sse
+
+// https://html.spec.whatwg.org/multipage/server-sent-events.html
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ContentType is the content type of the event streams.
+const ContentType = "text/event-stream"
+
+// Event is an event of a stream. Event and ID must not contain line breaks.
+type Event struct {
+	// ID is the id of the event, sent back by the reconnecting clients
+	// in the Last-Event-ID header.
+	ID string
+	// Event is the type of the event, "message" when empty.
+	Event string
+	// Data is the payload of the event, it may span several lines.
+	Data string
+}
+
+// ErrTooSlow is the error of the subscriptions lagging behind the buffer
+// size of the hub.
+var ErrTooSlow = errors.New("sse: subscriber too slow")
+
+// Option configures a Hub.
+type Option func(*Hub)
+
+// WithBufferSize keeps the last size events for the replay to the
+// reconnecting clients, 64 by default. It is also the number of events
+// a subscriber may lag behind before being disconnected.
+func WithBufferSize(size int) Option {
+	return func(h *Hub) {
+		h.size = size
+	}
+}
+
+// WithHeartbeat writes a comment to the idle streams every interval,
+// 15 seconds by default, which keeps the proxies and the idle deadlines
+// from closing them. Zero disables the heartbeats.
+func WithHeartbeat(interval time.Duration) Option {
+	return func(h *Hub) {
+		h.heartbeat = interval
+	}
+}
+
+// WithRetry asks the clients to wait for delay before reconnecting.
+func WithRetry(delay time.Duration) Option {
+	return func(h *Hub) {
+		h.retry = delay
+	}
+}
+
+// Hub broadcasts the published events to all its subscribers, the
+// clients of its event stream.
+//
+// The events are numbered from 1 and the last ones are kept in a ring
+// buffer: a client reconnecting with a Last-Event-ID header gets the events
+// it missed before the new ones, or the whole buffer when the id is unknown.
+//
+// A subscriber lagging behind the buffer size is disconnected, so the
+// publisher never blocks. When it reconnects in time, it resumes without
+// losing events.
+type Hub struct {
+	size      int
+	heartbeat time.Duration
+	retry     time.Duration
+
+	mu     sync.Mutex
+	events []Event // ring buffer
+	last   uint64  // id of the last event
+	subs   map[*Subscription]bool
+	closed bool
+}
+
+// NewHub creates a Hub.
+func NewHub(opts ...Option) *Hub {
+	h := &Hub{
+		size:      64,
+		heartbeat: 15 * time.Second,
+		subs:      make(map[*Subscription]bool),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	if h.size < 1 {
+		h.size = 1
+	}
+	h.events = make([]Event, h.size)
+	return h
+}
+
+// Publish assigns the next id to the event and sends it to the subscribers.
+// The events published after Close are discarded.
+func (h *Hub) Publish(event, data string) Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return Event{Event: event, Data: data}
+	}
+
+	h.last++
+	e := Event{ID: strconv.FormatUint(h.last, 10), Event: event, Data: data}
+	h.events[h.last%uint64(h.size)] = e
+
+	for sub := range h.subs {
+		select {
+		case sub.events <- e:
+		default:
+			// too slow, the client will resume from the buffer
+			h.drop(sub, ErrTooSlow)
+		}
+	}
+
+	return e
+}
+
+// Subscribers returns the number of clients of the event stream.
+func (h *Hub) Subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.subs)
+}
+
+// Close disconnects the subscribers, the new clients get a 204 No Content
+// response which stops their reconnections.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for sub := range h.subs {
+		h.drop(sub, ErrClosed)
+	}
+}
+
+// Subscription receives the events of a Hub.
+type Subscription struct {
+	hub    *Hub
+	events chan Event
+	err    error
+}
+
+// Subscribe registers a subscriber, and returns the events following lastID
+// like the Last-Event-ID header of ServeHTTP. It returns ErrClosed after Close.
+func (h *Hub) Subscribe(lastID string) (*Subscription, []Event, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil, nil, ErrClosed
+	}
+
+	var replay []Event
+	if lastID != "" {
+		oldest := uint64(1)
+		if h.last > uint64(h.size) {
+			oldest = h.last - uint64(h.size) + 1
+		}
+		// the unknown ids, of a previous hub for instance, get all the events
+		if id, err := strconv.ParseUint(lastID, 10, 64); err == nil && id <= h.last && id >= oldest-1 {
+			oldest = id + 1
+		}
+		for id := oldest; id <= h.last; id++ {
+			replay = append(replay, h.events[id%uint64(h.size)])
+		}
+	}
+
+	sub := &Subscription{hub: h, events: make(chan Event, h.size)}
+	h.subs[sub] = true
+
+	return sub, replay, nil
+}
+
+// Events returns the published events. The channel is closed by Close,
+// and when the subscriber lags behind the buffer size.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Err returns why Events was closed: ErrClosed after the Close of the hub,
+// ErrTooSlow for a lagging subscriber, nil otherwise.
+func (s *Subscription) Err() error {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	return s.err
+}
+
+// Close unregisters the subscriber.
+func (s *Subscription) Close() {
+	h := s.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.subs[s] {
+		h.drop(s, nil)
+	}
+}
+
+// drop must be called with the lock held.
+func (h *Hub) drop(sub *Subscription, err error) {
+	sub.err = err
+	delete(h.subs, sub)
+	close(sub.events)
+}
+
+// ServeHTTP streams the events to the client until it disconnects, or
+// is disconnected by Close or for being too slow.
+//
+// The WriteTimeout of the server also limits the duration of the streams,
+// unless the hub is wrapped by a handler extending the deadlines on each
+// write, like cert.StreamingHandler.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "sse: streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	sub, replay, err := h.Subscribe(r.Header.Get("Last-Event-ID"))
+	if err != nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	// disables the buffering of nginx
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if h.retry > 0 {
+		fmt.Fprintf(w, "retry: %d\n\n", h.retry/time.Millisecond)
+	}
+	for _, e := range replay {
+		if err := writeEvent(w, e); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	var heartbeat <-chan time.Time
+	if h.heartbeat > 0 {
+		ticker := time.NewTicker(h.heartbeat)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+
+	for {
+		select {
+		case e, ok := <-sub.events:
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+
+		case <-heartbeat:
+			if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w io.Writer, e Event) error {
+	var b strings.Builder
+	if e.ID != "" {
+		fmt.Fprintf(&b, "id: %s\n", e.ID)
+	}
+	if e.Event != "" {
+		fmt.Fprintf(&b, "event: %s\n", e.Event)
+	}
+	data := strings.ReplaceAll(e.Data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
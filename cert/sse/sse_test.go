@@ -0,0 +1,248 @@
+package sse
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var errDone = errors.New("done")
+
+// collect subscribes until n events are received.
+func collect(client *Client, n int) ([]Event, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var events []Event
+	err := client.Subscribe(ctx, func(e Event) error {
+		events = append(events, e)
+		if len(events) == n {
+			return errDone
+		}
+		return nil
+	})
+	if err != errDone {
+		return events, err
+	}
+	return events, nil
+}
+
+func ids(events []Event) string {
+	var s []string
+	for _, e := range events {
+		s = append(s, e.ID)
+	}
+	return strings.Join(s, ",")
+}
+
+func waitSubscribers(t *testing.T, hub *Hub, n int) {
+	t.Helper()
+	for i := 0; hub.Subscribers() != n; i++ {
+		if i == 100 {
+			t.Fatalf("subscribers: %d, want %d", hub.Subscribers(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHubReplay(t *testing.T) {
+	hub := NewHub(WithBufferSize(4))
+	server := httptest.NewServer(hub)
+	defer server.Close()
+
+	for i := 1; i <= 6; i++ {
+		hub.Publish("tick", fmt.Sprintf("line %d\nof %d", i, i))
+	}
+
+	for _, test := range []struct {
+		lastID string
+		want   string
+	}{
+		{"5", "6"},
+		{"3", "4,5,6"},
+		{"2", "3,4,5,6"},
+		// out of the buffer
+		{"1", "3,4,5,6"},
+		{"99", "3,4,5,6"},
+		{"invalid", "3,4,5,6"},
+	} {
+		client := &Client{URL: server.URL, LastEventID: test.lastID}
+		events, err := collect(client, strings.Count(test.want, ",")+1)
+		if err != nil {
+			t.Fatalf("last id %s: %v", test.lastID, err)
+		}
+		if got := ids(events); got != test.want {
+			t.Errorf("last id %s: replayed %s, want %s", test.lastID, got, test.want)
+		}
+		if e := events[0]; e.Event != "tick" || e.Data != fmt.Sprintf("line %s\nof %s", e.ID, e.ID) {
+			t.Errorf("last id %s: unexpected event %+v", test.lastID, e)
+		}
+	}
+}
+
+func TestHubBroadcast(t *testing.T) {
+	hub := NewHub()
+	server := httptest.NewServer(hub)
+	defer server.Close()
+
+	hub.Publish("", "before")
+
+	results := make(chan string, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			events, err := collect(&Client{URL: server.URL}, 5)
+			if err != nil {
+				results <- err.Error()
+				return
+			}
+			if events[0].Event != "message" {
+				results <- "unexpected type: " + events[0].Event
+				return
+			}
+			results <- ids(events)
+		}()
+	}
+	waitSubscribers(t, hub, 3)
+
+	for i := 0; i < 5; i++ {
+		hub.Publish("", "after")
+	}
+	for i := 0; i < 3; i++ {
+		if got := <-results; got != "2,3,4,5,6" {
+			t.Errorf("subscriber got %s", got)
+		}
+	}
+}
+
+func TestHubHeartbeat(t *testing.T) {
+	hub := NewHub(WithHeartbeat(20*time.Millisecond), WithRetry(1500*time.Millisecond))
+	server := httptest.NewServer(hub)
+	defer server.Close()
+
+	res, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if ct := res.Header.Get("Content-Type"); ct != ContentType {
+		t.Errorf("content type: %s", ct)
+	}
+
+	r := bufio.NewReader(res.Body)
+	var lines []string
+	for len(lines) < 4 {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	want := []string{"retry: 1500\n", "\n", ": heartbeat\n", "\n"}
+	if fmt.Sprint(lines) != fmt.Sprint(want) {
+		t.Errorf("got %q, want %q", lines, want)
+	}
+}
+
+func TestClientResume(t *testing.T) {
+	hub := NewHub(WithRetry(200 * time.Millisecond))
+	server := httptest.NewServer(hub)
+	defer server.Close()
+
+	client := &Client{URL: server.URL}
+	done := make(chan error)
+	var events []Event
+	go func() {
+		var err error
+		events, err = collect(client, 6)
+		done <- err
+	}()
+
+	waitSubscribers(t, hub, 1)
+	hub.Publish("", "1")
+	hub.Publish("", "2")
+	hub.Publish("", "3")
+
+	for i := 0; i < 2; i++ {
+		time.Sleep(50 * time.Millisecond)
+		server.CloseClientConnections()
+		waitSubscribers(t, hub, 0)
+
+		// missed while disconnected
+		hub.Publish("", fmt.Sprint(4+i))
+	}
+	waitSubscribers(t, hub, 1)
+	hub.Publish("", "6")
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(events); got != "1,2,3,4,5,6" {
+		t.Errorf("received %s", got)
+	}
+	if client.LastEventID != "6" || client.Retry != 200*time.Millisecond {
+		t.Errorf("client state: %q %v", client.LastEventID, client.Retry)
+	}
+}
+
+func TestHubSlowSubscriber(t *testing.T) {
+	hub := NewHub(WithBufferSize(4))
+
+	sub, _, err := hub.Subscribe("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		hub.Publish("", "")
+	}
+	if hub.Subscribers() != 0 {
+		t.Fatal("slow subscriber not dropped")
+	}
+	if err := sub.Err(); err != ErrTooSlow {
+		t.Errorf("dropped subscription error: %v", err)
+	}
+
+	// the queued events are delivered before the disconnection
+	var last string
+	for e := range sub.Events() {
+		last = e.ID
+	}
+	if last != "4" {
+		t.Fatalf("last queued event: %s", last)
+	}
+
+	_, replay, err := hub.Subscribe(last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(replay); got != "5" {
+		t.Errorf("resumed with %s", got)
+	}
+}
+
+func TestHubClose(t *testing.T) {
+	hub := NewHub()
+	server := httptest.NewServer(hub)
+	defer server.Close()
+
+	done := make(chan error)
+	go func() {
+		_, err := collect(&Client{URL: server.URL, Retry: 10 * time.Millisecond}, 1)
+		done <- err
+	}()
+	waitSubscribers(t, hub, 1)
+
+	hub.Close()
+	if err := <-done; err != ErrClosed {
+		t.Errorf("subscribe after close: %v", err)
+	}
+	if _, _, err := hub.Subscribe(""); err != ErrClosed {
+		t.Errorf("hub subscribe after close: %v", err)
+	}
+}
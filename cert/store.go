@@ -0,0 +1,357 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// KeyStore stores the keys, certificates, CRLs and index of a PKI as
+// named files, such as <cn>.key, <cn>.crt, <ca>.crl and index.txt.
+// Missing files are reported with errors satisfying os.IsNotExist.
+type KeyStore interface {
+	// ReadFile returns the content of the named file.
+	ReadFile(name string) ([]byte, error)
+
+	// WriteFile atomically creates or replaces the named file, so that
+	// readers never see a partially written file.
+	WriteFile(name string, data []byte, perm os.FileMode) error
+
+	// CreateFile creates the named file, which must not exist:
+	// the error satisfies os.IsExist otherwise.
+	CreateFile(name string, data []byte, perm os.FileMode) error
+
+	// Stat describes the named file, its size and modification time are
+	// used to detect changes.
+	Stat(name string) (os.FileInfo, error)
+
+	// List returns the sorted names of the files matching the pattern,
+	// with the syntax of filepath.Match.
+	List(pattern string) ([]string, error)
+}
+
+// Dir is a KeyStore of the files in a directory of the filesystem.
+type Dir string
+
+func (d Dir) path(name string) string {
+	return filepath.Join(string(d), filepath.Base(name))
+}
+
+func (d Dir) ReadFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(d.path(name))
+}
+
+func (d Dir) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return writeFileAtomic(d.path(name), data, perm)
+}
+
+func (d Dir) CreateFile(name string, data []byte, perm os.FileMode) error {
+	// O_EXCL: file must not exist
+	file, err := os.OpenFile(d.path(name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func (d Dir) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(d.path(name))
+}
+
+func (d Dir) List(pattern string) ([]string, error) {
+	paths, err := filepath.Glob(d.path(pattern))
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(paths))
+	for i, path := range paths {
+		names[i] = filepath.Base(path)
+	}
+	return names, nil
+}
+
+// MemStore is a KeyStore in memory, for tests and ephemeral PKIs.
+type MemStore struct {
+	mu    sync.RWMutex
+	files map[string]*memFile
+}
+
+// NewMemStore creates an empty MemStore.
+func NewMemStore() *MemStore {
+	return &MemStore{files: make(map[string]*memFile)}
+}
+
+func (s *MemStore) ReadFile(name string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	file, ok := s.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return append([]byte(nil), file.data...), nil
+}
+
+func (s *MemStore) WriteFile(name string, data []byte, perm os.FileMode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.files[name] = newMemFile(name, data, perm)
+	return nil
+}
+
+func (s *MemStore) CreateFile(name string, data []byte, perm os.FileMode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.files[name]; ok {
+		return &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	}
+	s.files[name] = newMemFile(name, data, perm)
+	return nil
+}
+
+func (s *MemStore) Stat(name string) (os.FileInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	file, ok := s.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return file, nil
+}
+
+func (s *MemStore) List(pattern string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var names []string
+	for name := range s.files {
+		ok, err := filepath.Match(pattern, name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// memFile is an immutable file of a MemStore, replaced on write.
+type memFile struct {
+	name    string
+	data    []byte
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func newMemFile(name string, data []byte, perm os.FileMode) *memFile {
+	return &memFile{
+		name:    name,
+		data:    append([]byte(nil), data...),
+		mode:    perm,
+		modTime: time.Now(),
+	}
+}
+
+func (f *memFile) Name() string       { return f.name }
+func (f *memFile) Size() int64        { return int64(len(f.data)) }
+func (f *memFile) Mode() os.FileMode  { return f.mode }
+func (f *memFile) ModTime() time.Time { return f.modTime }
+func (f *memFile) IsDir() bool        { return false }
+func (f *memFile) Sys() interface{}   { return nil }
+
+// Vault is a KeyStore of files kept in a single file encrypted with a
+// password: the files are held in memory and the vault file is rewritten
+// on each change. The key is derived with scrypt and the content sealed
+// with AES-256-GCM.
+type Vault struct {
+	path string
+	key  []byte
+	salt []byte
+
+	// mu serializes the writes of the vault file
+	mu  sync.Mutex
+	mem *MemStore
+}
+
+const vaultMagic = "CERTVAULT1"
+
+type vaultEntry struct {
+	Data    []byte      `json:"data"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mod_time"`
+}
+
+// OpenVault opens the vault file at path, it is created on the first
+// write when it does not exist.
+func OpenVault(path, password string) (*Vault, error) {
+	v := &Vault{path: path, mem: NewMemStore()}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		v.salt = make([]byte, scryptSaltLen)
+		if _, err := rand.Read(v.salt); err != nil {
+			return nil, err
+		}
+		v.key, err = scrypt.Key([]byte(password), v.salt, scryptN, scryptR, scryptP, 32)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(data, []byte(vaultMagic)) || len(data) < len(vaultMagic)+scryptSaltLen {
+		return nil, fmt.Errorf("invalid vault file: %q", path)
+	}
+	data = data[len(vaultMagic):]
+	v.salt, data = data[:scryptSaltLen], data[scryptSaltLen:]
+
+	v.key, err = scrypt.Key([]byte(password), v.salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := v.aead()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("invalid vault file: %q", path)
+	}
+	nonce, data := data[:aead.NonceSize()], data[aead.NonceSize():]
+
+	plaintext, err := aead.Open(nil, nonce, data, []byte(vaultMagic))
+	if err != nil {
+		return nil, errDecryptKey
+	}
+
+	var entries map[string]vaultEntry
+	if err := json.Unmarshal(plaintext, &entries); err != nil {
+		return nil, fmt.Errorf("decode vault: %v", err)
+	}
+	for name, entry := range entries {
+		v.mem.files[name] = &memFile{
+			name:    name,
+			data:    entry.Data,
+			mode:    entry.Mode,
+			modTime: entry.ModTime,
+		}
+	}
+
+	return v, nil
+}
+
+func (v *Vault) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(v.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// save rewrites the vault file with the files in memory and the given
+// file, v.mu must be held.
+func (v *Vault) save(file *memFile) error {
+	v.mem.mu.RLock()
+	entries := make(map[string]vaultEntry, len(v.mem.files)+1)
+	for name, file := range v.mem.files {
+		entries[name] = vaultEntry{
+			Data:    file.data,
+			Mode:    file.mode,
+			ModTime: file.modTime,
+		}
+	}
+	v.mem.mu.RUnlock()
+	entries[file.name] = vaultEntry{
+		Data:    file.data,
+		Mode:    file.mode,
+		ModTime: file.modTime,
+	}
+
+	plaintext, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	aead, err := v.aead()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	data := append([]byte(vaultMagic), v.salt...)
+	data = append(data, nonce...)
+	data = aead.Seal(data, nonce, plaintext, []byte(vaultMagic))
+
+	return writeFileAtomic(v.path, data, 0600)
+}
+
+func (v *Vault) ReadFile(name string) ([]byte, error) {
+	return v.mem.ReadFile(name)
+}
+
+func (v *Vault) WriteFile(name string, data []byte, perm os.FileMode) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	return v.write(newMemFile(name, data, perm))
+}
+
+func (v *Vault) CreateFile(name string, data []byte, perm os.FileMode) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if _, err := v.mem.Stat(name); err == nil {
+		return &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	}
+	return v.write(newMemFile(name, data, perm))
+}
+
+// write saves the vault file with file, then adds file to the memory,
+// which is left unchanged when the save fails. v.mu must be held.
+func (v *Vault) write(file *memFile) error {
+	if err := v.save(file); err != nil {
+		return err
+	}
+
+	v.mem.mu.Lock()
+	v.mem.files[file.name] = file
+	v.mem.mu.Unlock()
+	return nil
+}
+
+func (v *Vault) Stat(name string) (os.FileInfo, error) {
+	return v.mem.Stat(name)
+}
+
+func (v *Vault) List(pattern string) ([]string, error) {
+	return v.mem.List(pattern)
+}
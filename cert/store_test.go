@@ -0,0 +1,90 @@
+package cert
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestKeyStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Mkdir(filepath.Join(dir, "pki"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	vault, err := OpenVault(filepath.Join(dir, "pki.vault"), "secret")
+	if err != nil {
+		t.Fatalf("open vault: %v", err)
+	}
+
+	for name, store := range map[string]KeyStore{
+		"dir":   Dir(filepath.Join(dir, "pki")),
+		"mem":   NewMemStore(),
+		"vault": vault,
+	} {
+		if _, err := store.ReadFile("ca.key"); !os.IsNotExist(err) {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if _, err := store.Stat("ca.key"); !os.IsNotExist(err) {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+
+		if err := CreateCerts(store, "ca", "localhost", "client", profiles); err != nil {
+			t.Fatalf("%s: create certs: %v", name, err)
+		}
+		if err := store.CreateFile("ca.key", nil, 0400); !os.IsExist(err) {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+
+		names, err := store.List("*.crt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(names, []string{"ca.crt", "client.crt", "localhost.crt"}) {
+			t.Fatalf("%s: unexpected names: %v", name, names)
+		}
+
+		if _, err := ReadTLSCert(store, "localhost", ""); err != nil {
+			t.Fatalf("%s: read server cert: %v", name, err)
+		}
+		if _, err := NewTLSConfig(store, "ca", "localhost"); err != nil {
+			t.Fatalf("%s: create tls config: %v", name, err)
+		}
+		if _, err := NewTLSClient(store, "ca", "client"); err != nil {
+			t.Fatalf("%s: create tls client: %v", name, err)
+		}
+	}
+
+	if _, err := OpenVault(filepath.Join(dir, "pki.vault"), "wrong"); err == nil {
+		t.Fatal("open vault with a wrong password")
+	}
+
+	reopened, err := OpenVault(filepath.Join(dir, "pki.vault"), "secret")
+	if err != nil {
+		t.Fatalf("reopen vault: %v", err)
+	}
+	if _, err := ReadTLSCert(reopened, "ca", "ca"); err != nil {
+		t.Fatalf("read ca cert from reopened vault: %v", err)
+	}
+
+	// the files are not kept in memory when the vault file is not saved
+	reopened.path = filepath.Join(dir, "missing", "pki.vault")
+	if err := reopened.WriteFile("ca.crt", []byte("lost"), 0644); err == nil {
+		t.Fatal("write to an unsaved vault")
+	}
+	if err := reopened.CreateFile("new.crt", []byte("lost"), 0644); err == nil {
+		t.Fatal("create in an unsaved vault")
+	}
+	if _, err := ReadTLSCert(reopened, "ca", "ca"); err != nil {
+		t.Fatalf("read ca cert after failed write: %v", err)
+	}
+	if _, err := reopened.Stat("new.crt"); !os.IsNotExist(err) {
+		t.Fatalf("unexpected file after failed create: %v", err)
+	}
+}
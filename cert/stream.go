@@ -0,0 +1,441 @@
+package cert
+
+// https://posener.github.io/http2/#full-duplex-communication
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// StreamContentType is the content type of the requests and the responses
+// of the streams.
+const StreamContentType = "application/x-cert-stream"
+
+// MaxMessageSize is the maximum size of the messages of a Stream.
+const MaxMessageSize = 1 << 20
+
+// A frame is a type byte, a big endian uint32 length and the payload.
+const (
+	frameData byte = iota
+	framePing
+	framePong
+	frameClose
+
+	frameHeaderSize = 5
+)
+
+var (
+	// ErrStreamClosed is returned by Send after Close.
+	ErrStreamClosed = errors.New("stream: closed")
+	// ErrStreamTimeout is returned when the peer does not answer the pings
+	// or the close frame in time.
+	ErrStreamTimeout = errors.New("stream: timeout")
+)
+
+// StreamOption configures a Stream created by StreamHandler or DialStream.
+type StreamOption func(*streamOptions)
+
+type streamOptions struct {
+	keepAlive    time.Duration
+	timeout      time.Duration
+	closeTimeout time.Duration
+}
+
+// WithKeepAlive sends a ping frame every interval, and fails the stream
+// when nothing is received from the peer for timeout. The pings of the
+// peer are always answered.
+func WithKeepAlive(interval, timeout time.Duration) StreamOption {
+	return func(o *streamOptions) {
+		o.keepAlive = interval
+		o.timeout = timeout
+	}
+}
+
+// WithCloseTimeout sets how long Close waits for the close frame
+// of the peer, 5 seconds by default.
+func WithCloseTimeout(timeout time.Duration) StreamOption {
+	return func(o *streamOptions) {
+		o.closeTimeout = timeout
+	}
+}
+
+// Stream is a full-duplex stream of messages over a single HTTP/2 request:
+// the client writes its frames to the request body and the server to the
+// response body. Send and Recv may be called concurrently.
+type Stream struct {
+	options streamOptions
+	r       *bufio.Reader
+
+	wmu         sync.Mutex
+	w           io.Writer
+	flush       func()
+	writeClosed bool
+	// closeWrite ends the request body of the client
+	closeWrite func() error
+	// release frees the request of the client
+	release func()
+	// interrupt unblocks the writes in progress
+	interrupt func(err error)
+
+	msgs    chan []byte
+	done    chan struct{} // closed when the read loop ends
+	readErr error         // io.EOF after the close frame of the peer
+
+	mu       sync.Mutex
+	lastRecv time.Time
+
+	closing   chan struct{} // closed by Close, the messages are discarded
+	closeOnce sync.Once
+	closeErr  error
+
+	aborted   chan struct{}
+	abortOnce sync.Once
+	abortErr  error
+}
+
+func newStream(r io.Reader, w io.Writer, flush func(), opts []StreamOption) *Stream {
+	s := &Stream{
+		options:  streamOptions{closeTimeout: 5 * time.Second},
+		r:        bufio.NewReader(r),
+		w:        w,
+		flush:    flush,
+		msgs:     make(chan []byte),
+		done:     make(chan struct{}),
+		lastRecv: time.Now(),
+		closing:  make(chan struct{}),
+		aborted:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(&s.options)
+	}
+	return s
+}
+
+func (s *Stream) start() {
+	go s.readLoop()
+	if s.options.keepAlive > 0 {
+		go s.keepAlive()
+		go s.idleCheck()
+	}
+}
+
+// Send sends a message.
+func (s *Stream) Send(msg []byte) error {
+	if len(msg) > MaxMessageSize {
+		return fmt.Errorf("stream: message of %d bytes exceeds %d", len(msg), MaxMessageSize)
+	}
+	return s.writeFrame(frameData, msg)
+}
+
+// Recv returns the next message, or io.EOF when the peer closed the stream.
+func (s *Stream) Recv() ([]byte, error) {
+	select {
+	case msg := <-s.msgs:
+		return msg, nil
+	case <-s.done:
+		return nil, s.readErr
+	case <-s.aborted:
+		return nil, s.abortErr
+	}
+}
+
+// Close sends a close frame and ends the sending side of the stream, then
+// waits for the close frame of the peer, discarding its messages. Once the
+// peer closed the stream, Close does not wait. The close timeout also
+// covers the sending of the close frame to a peer which stopped reading.
+func (s *Stream) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closing)
+
+		// the abort unblocks the write of the close frame
+		timer := time.AfterFunc(s.options.closeTimeout, func() {
+			s.abort(ErrStreamTimeout)
+		})
+
+		s.closeErr = s.writeFrame(frameClose, nil)
+
+		s.wmu.Lock()
+		s.writeClosed = true
+		if s.closeWrite != nil {
+			s.closeWrite()
+		}
+		s.wmu.Unlock()
+
+		select {
+		case <-s.done:
+		case <-s.aborted:
+		}
+		if !timer.Stop() {
+			s.closeErr = ErrStreamTimeout
+		}
+
+		if s.release != nil {
+			s.release()
+		}
+	})
+	return s.closeErr
+}
+
+// abort fails the stream, without waiting for the writes in progress.
+func (s *Stream) abort(err error) {
+	s.abortOnce.Do(func() {
+		s.abortErr = err
+		close(s.aborted)
+		if s.interrupt != nil {
+			s.interrupt(err)
+		}
+		if s.release != nil {
+			s.release()
+		}
+	})
+}
+
+func (s *Stream) writeFrame(typ byte, payload []byte) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	if s.writeClosed {
+		return ErrStreamClosed
+	}
+	select {
+	case <-s.aborted:
+		return s.abortErr
+	default:
+	}
+
+	var header [frameHeaderSize]byte
+	header[0] = typ
+	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+
+	if _, err := s.w.Write(header[:]); err != nil {
+		return s.writeErr(err)
+	}
+	if _, err := s.w.Write(payload); err != nil {
+		return s.writeErr(err)
+	}
+	s.flush()
+
+	return nil
+}
+
+// writeErr returns the error of the abort which interrupted a write.
+func (s *Stream) writeErr(err error) error {
+	select {
+	case <-s.aborted:
+		return s.abortErr
+	default:
+		return err
+	}
+}
+
+func (s *Stream) readFrame() (byte, []byte, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(s.r, header[:]); err != nil {
+		if err == io.EOF {
+			// the body ended without close frame
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > MaxMessageSize {
+		return 0, nil, fmt.Errorf("stream: frame of %d bytes exceeds %d", size, MaxMessageSize)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(s.r, payload); err != nil {
+		return 0, nil, err
+	}
+
+	return header[0], payload, nil
+}
+
+func (s *Stream) readLoop() {
+	defer close(s.done)
+
+	for {
+		typ, payload, err := s.readFrame()
+		if err != nil {
+			s.readErr = err
+			return
+		}
+
+		s.mu.Lock()
+		s.lastRecv = time.Now()
+		s.mu.Unlock()
+
+		switch typ {
+		case frameData:
+			select {
+			case s.msgs <- payload:
+			case <-s.closing:
+			case <-s.aborted:
+				s.readErr = s.abortErr
+				return
+			}
+		case framePing:
+			// fails after Close, the peer will stop pinging
+			s.writeFrame(framePong, payload)
+		case framePong:
+		case frameClose:
+			s.readErr = io.EOF
+			return
+		default:
+			s.readErr = fmt.Errorf("stream: invalid frame type %d", typ)
+			return
+		}
+	}
+}
+
+// keepAlive sends the pings, which may block behind the writes to a peer
+// which stopped reading.
+func (s *Stream) keepAlive() {
+	ticker := time.NewTicker(s.options.keepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.aborted:
+			return
+		case <-ticker.C:
+		}
+		s.writeFrame(framePing, nil)
+	}
+}
+
+// idleCheck fails the stream when nothing is received for the timeout.
+func (s *Stream) idleCheck() {
+	timer := time.NewTimer(s.options.timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.aborted:
+			return
+		case <-timer.C:
+		}
+
+		s.mu.Lock()
+		idle := time.Since(s.lastRecv)
+		s.mu.Unlock()
+
+		if idle >= s.options.timeout {
+			s.abort(ErrStreamTimeout)
+			return
+		}
+		timer.Reset(s.options.timeout - idle)
+	}
+}
+
+// StreamHandler serves the streams of the HTTP/2 requests with fn, the
+// stream is closed when fn returns. The other requests get a 505 HTTP
+// Version Not Supported response.
+//
+// The ReadTimeout and the WriteTimeout of the server, like the ones of
+// NewTLSServer, also limit the duration of the streams, unless the handler
+// is wrapped by StreamingHandler.
+func StreamHandler(fn func(r *http.Request, s *Stream) error, opts ...StreamOption) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if r.ProtoMajor != 2 || !ok {
+			// the endless body of the request is not drained
+			w.Header().Set("Connection", "close")
+			http.Error(w, "stream: HTTP/2 required", http.StatusHTTPVersionNotSupported)
+			return
+		}
+
+		w.Header().Set("Content-Type", StreamContentType)
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		s := newStream(r.Body, w, flusher.Flush, opts)
+		// the expired deadline fails the blocked writes
+		rc := http.NewResponseController(w)
+		s.interrupt = func(error) {
+			rc.SetWriteDeadline(time.Now())
+		}
+		s.start()
+
+		if err := fn(r, s); err != nil {
+			log.Printf("stream %s: %v", r.URL.Path, err)
+		}
+		s.Close()
+	})
+}
+
+// EchoStream sends back the messages of the stream, for StreamHandler.
+func EchoStream(r *http.Request, s *Stream) error {
+	for {
+		msg, err := s.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.Send(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// DialStream opens a stream to the StreamHandler served at url. The client
+// must negotiate HTTP/2, like NewTLSClient with WithALPN("h2"), its Timeout
+// is ignored. Cancelling ctx aborts the stream.
+func DialStream(ctx context.Context, client *http.Client, url string, opts ...StreamOption) (*Stream, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	// the frames written to the pipe are sent as the request body,
+	// while the response body is read
+	pr, pw := io.Pipe()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, pr)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("create stream request: %v", err)
+	}
+	req.Header.Set("Content-Type", StreamContentType)
+
+	// the deadline of the client would end the stream
+	streamClient := *client
+	streamClient.Timeout = 0
+
+	res, err := streamClient.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		data, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		res.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("stream: %s: %s", res.Status, data)
+	}
+
+	s := newStream(res.Body, pw, func() {}, opts)
+	s.closeWrite = pw.Close
+	s.interrupt = func(err error) {
+		pw.CloseWithError(err)
+	}
+	s.release = func() {
+		cancel()
+		res.Body.Close()
+	}
+	s.start()
+
+	return s, nil
+}
@@ -0,0 +1,176 @@
+package cert
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestStream(t *testing.T) {
+	handler := StreamHandler(EchoStream, WithKeepAlive(20*time.Millisecond, time.Second))
+
+	withHandler(pki, handler, func(url string) {
+		client, err := NewTLSClient(pki, "ca", "client", WithALPN("h2"))
+		if err != nil {
+			t.Fatalf("create client: %v", err)
+		}
+
+		s, err := DialStream(context.Background(), client, url, WithKeepAlive(20*time.Millisecond, time.Second))
+		if err != nil {
+			t.Fatalf("dial stream: %v", err)
+		}
+
+		// the messages are echoed while the request is being sent
+		for i := 0; i < 10; i++ {
+			msg := fmt.Sprintf("message %d", i)
+			if err := s.Send([]byte(msg)); err != nil {
+				t.Fatalf("send: %v", err)
+			}
+			got, err := s.Recv()
+			if err != nil {
+				t.Fatalf("recv: %v", err)
+			}
+			if string(got) != msg {
+				t.Fatalf("unexpected message: %q", got)
+			}
+			// a few pings are exchanged
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if err := s.Send(make([]byte, MaxMessageSize+1)); err == nil {
+			t.Fatal("large message sent")
+		}
+
+		if err := s.Close(); err != nil {
+			t.Fatalf("close: %v", err)
+		}
+		if _, err := s.Recv(); err != io.EOF {
+			t.Fatalf("unexpected error after close: %v", err)
+		}
+		if err := s.Send([]byte("closed")); err != ErrStreamClosed {
+			t.Fatalf("unexpected error after close: %v", err)
+		}
+	})
+}
+
+func TestStreamServerClose(t *testing.T) {
+	handler := StreamHandler(func(r *http.Request, s *Stream) error {
+		return s.Send([]byte("bye"))
+	})
+
+	withHandler(pki, handler, func(url string) {
+		client, err := NewTLSClient(pki, "ca", "client", WithALPN("h2"))
+		if err != nil {
+			t.Fatalf("create client: %v", err)
+		}
+
+		s, err := DialStream(context.Background(), client, url)
+		if err != nil {
+			t.Fatalf("dial stream: %v", err)
+		}
+		defer s.Close()
+
+		msg, err := s.Recv()
+		if err != nil || string(msg) != "bye" {
+			t.Fatalf("unexpected message: %q %v", msg, err)
+		}
+		if _, err := s.Recv(); err != io.EOF {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestStreamHTTP1(t *testing.T) {
+	withHandler(pki, StreamHandler(EchoStream), func(url string) {
+		client, err := NewTLSClient(pki, "ca", "client")
+		if err != nil {
+			t.Fatalf("create client: %v", err)
+		}
+		if _, err := DialStream(context.Background(), client, url); err == nil {
+			t.Fatal("stream over HTTP/1.1")
+		}
+	})
+}
+
+func TestStreamBlockedWriter(t *testing.T) {
+	sent := make(chan error, 1)
+	blocked := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/server" {
+			StreamHandler(func(r *http.Request, s *Stream) error {
+				for {
+					if err := s.Send(make([]byte, MaxMessageSize)); err != nil {
+						sent <- err
+						return err
+					}
+				}
+			}, WithKeepAlive(20*time.Millisecond, 200*time.Millisecond), WithCloseTimeout(100*time.Millisecond)).ServeHTTP(w, r)
+			return
+		}
+		// the peer of the client neither reads nor answers the pings
+		w.Header().Set("Content-Type", StreamContentType)
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-blocked
+	})
+
+	withHandler(pki, handler, func(url string) {
+		defer close(blocked)
+
+		client, err := NewTLSClient(pki, "ca", "client", WithALPN("h2"))
+		if err != nil {
+			t.Fatalf("create client: %v", err)
+		}
+
+		s, err := DialStream(context.Background(), client, url+"/client",
+			WithKeepAlive(20*time.Millisecond, 200*time.Millisecond), WithCloseTimeout(100*time.Millisecond))
+		if err != nil {
+			t.Fatalf("dial stream: %v", err)
+		}
+		errc := make(chan error, 1)
+		go func() {
+			for {
+				if err := s.Send(make([]byte, MaxMessageSize)); err != nil {
+					errc <- err
+					return
+				}
+			}
+		}()
+		select {
+		case err := <-errc:
+			if err != ErrStreamTimeout {
+				t.Fatalf("unexpected client send error: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("client send still blocked")
+		}
+		if err := s.Close(); err != ErrStreamTimeout {
+			t.Fatalf("unexpected close error: %v", err)
+		}
+
+		// the client of the server neither reads nor answers the pings
+		pr, pw := io.Pipe()
+		defer pw.Close()
+		req, err := http.NewRequest(http.MethodPut, url+"/server", pr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		select {
+		case err := <-sent:
+			if err != ErrStreamTimeout {
+				t.Fatalf("unexpected server send error: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("server send still blocked")
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package cert
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// StreamingHandler lets the long-lived requests of handler outlive the
+// ReadTimeout and the WriteTimeout of the server, like the ones of
+// NewTLSServer: the read and write deadlines of each request are set to
+// idle, and both extended by idle after each successful read of the
+// request body and each successful write or flush of the response.
+//
+// A request is ended after idle without progress in either direction,
+// the streams of StreamHandler should send pings more often.
+func StreamingHandler(handler http.Handler, idle time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d := &idleDeadline{rc: http.NewResponseController(w), idle: idle}
+		d.extend()
+
+		r.Body = &streamingBody{ReadCloser: r.Body, deadline: d}
+		handler.ServeHTTP(&streamingWriter{ResponseWriter: w, deadline: d}, r)
+	})
+}
+
+type idleDeadline struct {
+	rc   *http.ResponseController
+	idle time.Duration
+}
+
+func (d *idleDeadline) extend() {
+	// the errors of unsupported deadlines keep the server timeouts
+	deadline := time.Now().Add(d.idle)
+	d.rc.SetReadDeadline(deadline)
+	d.rc.SetWriteDeadline(deadline)
+}
+
+type streamingWriter struct {
+	http.ResponseWriter
+	deadline *idleDeadline
+}
+
+func (w *streamingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	if err == nil {
+		w.deadline.extend()
+	}
+	return n, err
+}
+
+func (w *streamingWriter) Flush() {
+	if err := w.deadline.rc.Flush(); err == nil {
+		w.deadline.extend()
+	}
+}
+
+// Unwrap lets http.ResponseController find the methods of the ResponseWriter.
+func (w *streamingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+type streamingBody struct {
+	io.ReadCloser
+	deadline *idleDeadline
+}
+
+func (b *streamingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.deadline.extend()
+	}
+	return n, err
+}
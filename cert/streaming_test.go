@@ -0,0 +1,70 @@
+package cert
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStreamingHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/strict", StreamHandler(EchoStream))
+	mux.Handle("/streaming", StreamingHandler(StreamHandler(EchoStream), 300*time.Millisecond))
+
+	server := httptest.NewUnstartedServer(mux)
+	defer server.Close()
+
+	tlsConfig, err := NewTLSConfig(pki, "ca", "localhost")
+	if err != nil {
+		t.Fatalf("create tls config: %v", err)
+	}
+	server.TLS = tlsConfig
+	server.Config.ReadTimeout = 200 * time.Millisecond
+	server.Config.WriteTimeout = 200 * time.Millisecond
+	server.StartTLS()
+
+	client, err := NewTLSClient(pki, "ca", "client", WithALPN("h2"))
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	// exchanges messages for longer than the server timeouts
+	exchange := func(s *Stream) error {
+		for i := 0; i < 10; i++ {
+			if err := s.Send([]byte("ping")); err != nil {
+				return err
+			}
+			if _, err := s.Recv(); err != nil {
+				return err
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		return nil
+	}
+
+	strict, err := DialStream(context.Background(), client, server.URL+"/strict")
+	if err != nil {
+		t.Fatalf("dial stream: %v", err)
+	}
+	if err := exchange(strict); err == nil {
+		t.Fatal("stream outlived the server timeouts")
+	}
+	strict.Close()
+
+	streaming, err := DialStream(context.Background(), client, server.URL+"/streaming")
+	if err != nil {
+		t.Fatalf("dial stream: %v", err)
+	}
+	if err := exchange(streaming); err != nil {
+		t.Fatalf("streaming route: %v", err)
+	}
+
+	// the idle stream is ended
+	time.Sleep(500 * time.Millisecond)
+	if err := exchange(streaming); err == nil {
+		t.Fatal("idle stream not ended")
+	}
+	streaming.Close()
+}
@@ -5,96 +5,226 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
-	"path/filepath"
 	"time"
 )
 
-func ReadTLSCert(pkiPath, cn, password string) (tls.Certificate, error) {
-	key, err := ReadKey(pkiPath, cn, password)
+func ReadTLSCert(store KeyStore, cn, password string) (tls.Certificate, error) {
+	key, err := ReadKey(store, cn, password)
 	if err != nil {
 		return tls.Certificate{}, fmt.Errorf("read key: %v", err)
 	}
 
-	path := filepath.Join(pkiPath, cn+".crt")
-	log.Printf("reading %q certificate from %q\n", cn, path)
-
-	data, err := ioutil.ReadFile(path)
+	chain, err := ReadCertChain(store, cn)
 	if err != nil {
 		return tls.Certificate{}, err
 	}
 
-	block, _ := pem.Decode(data)
-	if block == nil {
-		return tls.Certificate{}, fmt.Errorf("no pem data found")
+	var certificate [][]byte
+	for _, cert := range chain {
+		certificate = append(certificate, cert.Raw)
 	}
 
-	if block.Type != "CERTIFICATE" {
-		return tls.Certificate{}, fmt.Errorf("invalid type: %v", block.Type)
+	return tls.Certificate{
+		Certificate: certificate,
+		PrivateKey:  key,
+		Leaf:        chain[0],
+	}, nil
+}
+
+func ReadCert(store KeyStore, cn string) (*x509.Certificate, error) {
+	chain, err := ReadCertChain(store, cn)
+	if err != nil {
+		return nil, err
 	}
 
-	leaf, err := x509.ParseCertificate(block.Bytes)
+	return chain[0], nil
+}
+
+// ReadCertChain reads all the certificates of <cn>.crt, the leaf first.
+func ReadCertChain(store KeyStore, cn string) ([]*x509.Certificate, error) {
+	name := cn + ".crt"
+	log.Printf("reading %q certificate from %q\n", cn, name)
+
+	data, err := store.ReadFile(name)
 	if err != nil {
-		return tls.Certificate{}, fmt.Errorf("decode cert: %v", err)
+		return nil, err
 	}
 
-	return tls.Certificate{
-		Certificate: [][]byte{block.Bytes},
-		PrivateKey:  key,
-		Leaf:        leaf,
-	}, nil
+	return ParseCertChain(data)
+}
+
+// ParseCertChain decodes the PEM encoded certificates of data, the leaf first.
+func ParseCertChain(data []byte) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("invalid type: %v", block.Type)
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("decode cert: %v", err)
+		}
+		chain = append(chain, cert)
+	}
+
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no pem data found")
+	}
+
+	return chain, nil
+}
+
+// TLSOption configures the tls.Config created by NewTLSConfig and NewTLSClient.
+type TLSOption func(*tlsOptions)
+
+type tlsOptions struct {
+	crl      bool
+	ocsp     bool
+	reloader *CertReloader
+
+	preset           TLSPreset
+	clientAuth       ClientAuthMode
+	alpn             []string
+	resumption       bool
+	sessionCacheSize int
+
+	pins *PinSet
+}
+
+// WithCRL rejects the client certificates listed in the <ca>.crl
+// of the KeyStore, the CRL is reloaded when it changes. The resumed
+// sessions are checked too.
+func WithCRL() TLSOption {
+	return func(o *tlsOptions) {
+		o.crl = true
+	}
 }
 
-func NewTLSConfig(pkiPath, ca, server string) (*tls.Config, error) {
-	clientCAs, err := NewCertPool(pkiPath, ca, false)
+// WithReloader serves the certificate of the reloader instead of reading
+// the keypair once. On the server side, the pool of client CAs is also
+// taken from the reloader.
+func WithReloader(reloader *CertReloader) TLSOption {
+	return func(o *tlsOptions) {
+		o.reloader = reloader
+	}
+}
+
+// NewTLSConfig creates the config of a server, by default with the
+// intermediate preset, h2 and the optional verification of the client
+// certificates issued by ca.
+func NewTLSConfig(store KeyStore, ca, server string, opts ...TLSOption) (*tls.Config, error) {
+	options, err := newTLSOptions(opts)
 	if err != nil {
-		return nil, fmt.Errorf("create ca pool: %v", err)
+		return nil, err
+	}
+
+	var clientCAs *x509.CertPool
+	var certificates []tls.Certificate
+
+	if options.reloader == nil {
+		clientCAs, err = NewCertPool(store, ca, false)
+		if err != nil {
+			return nil, fmt.Errorf("create ca pool: %v", err)
+		}
+
+		tlsServer, err := ReadTLSCert(store, server, "")
+		if err != nil {
+			return nil, fmt.Errorf("read server cert: %v", err)
+		}
+		certificates = []tls.Certificate{tlsServer}
 	}
 
-	tlsServer, err := ReadTLSCert(pkiPath, server, "")
+	tlsConfig, err := options.config(true)
 	if err != nil {
-		return nil, fmt.Errorf("read server cert: %v", err)
+		return nil, err
 	}
+	tlsConfig.Certificates = certificates
+	tlsConfig.ClientCAs = clientCAs
 
-	// https://blog.gopheracademy.com/advent-2016/exposing-go-on-the-internet/
-	return &tls.Config{
-		PreferServerCipherSuites: true,
-		SessionTicketsDisabled:   true,
-		CurvePreferences: []tls.CurveID{
-			tls.CurveP256,
-			tls.X25519,
-		},
-		MinVersion: tls.VersionTLS12,
-		CipherSuites: []uint16{
-			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305, // Go 1.8 only
-			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,   // Go 1.8 only
-			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
-			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-			// Best disabled, as they don't provide Forward Secrecy,
-			// but might be necessary for some clients
-			// tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
-			// tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
-		},
-		Certificates: []tls.Certificate{tlsServer},
-		NextProtos:   []string{"h2"},
-		ClientAuth:   tls.VerifyClientCertIfGiven,
-		ClientCAs:    clientCAs,
-	}, nil
+	if options.crl {
+		checker, err := newCRLChecker(store, ca)
+		if err != nil {
+			return nil, fmt.Errorf("create crl checker: %v", err)
+		}
+		tlsConfig.VerifyConnection = checker.VerifyConnection
+	}
+
+	if reloader := options.reloader; reloader != nil {
+		tlsConfig.GetCertificate = reloader.GetCertificate
+		tlsConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			config := tlsConfig.Clone()
+			config.GetConfigForClient = nil
+			config.ClientCAs = reloader.CertPool()
+			return config, nil
+		}
+	}
+
+	if options.ocsp {
+		getCertificate := tlsConfig.GetCertificate
+		if getCertificate == nil {
+			tlsServer := &tlsConfig.Certificates[0]
+			getCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+				return tlsServer, nil
+			}
+		}
+
+		stapler, err := newOCSPStapler(store, ca, getCertificate)
+		if err != nil {
+			return nil, fmt.Errorf("create ocsp stapler: %v", err)
+		}
+		tlsConfig.Certificates = nil
+		tlsConfig.GetCertificate = stapler.GetCertificate
+	}
+
+	return tlsConfig, nil
 }
 
-func NewTLSClient(pkiPath, ca, client string) (*http.Client, error) {
-	rootCAs, err := NewCertPool(pkiPath, ca, true)
+// NewTLSClient creates a client presenting the certificate of client to the
+// servers, which are verified against ca and the system roots, and against
+// the pins of WithPins. It uses the intermediate preset by default, the
+// client auth mode is ignored.
+func NewTLSClient(store KeyStore, ca, client string, opts ...TLSOption) (*http.Client, error) {
+	options, err := newTLSOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	rootCAs, err := NewCertPool(store, ca, true)
 	if err != nil {
 		return nil, fmt.Errorf("create ca pool: %v", err)
 	}
 
-	tlsClient, err := ReadTLSCert(pkiPath, client, "")
+	tlsClientConfig, err := options.config(false)
 	if err != nil {
-		return nil, fmt.Errorf("read server cert: %v", err)
+		return nil, err
+	}
+	tlsClientConfig.RootCAs = rootCAs
+
+	if options.pins != nil {
+		tlsClientConfig.VerifyConnection, err = options.pins.verifyConnection()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if options.reloader != nil {
+		tlsClientConfig.GetClientCertificate = options.reloader.GetClientCertificate
+	} else {
+		tlsClient, err := ReadTLSCert(store, client, "")
+		if err != nil {
+			return nil, fmt.Errorf("read server cert: %v", err)
+		}
+		tlsClientConfig.Certificates = []tls.Certificate{tlsClient}
 	}
 
 	return &http.Client{
@@ -104,22 +234,15 @@ func NewTLSClient(pkiPath, ca, client string) (*http.Client, error) {
 			ResponseHeaderTimeout: 10 * time.Second,
 			//
 			TLSHandshakeTimeout: 3 * time.Second,
-			TLSClientConfig: &tls.Config{
-				RootCAs:                  rootCAs,
-				MinVersion:               tls.VersionTLS12,
-				SessionTicketsDisabled:   true,
-				PreferServerCipherSuites: true,
-				Certificates:             []tls.Certificate{tlsClient},
-			},
+			TLSClientConfig:     tlsClientConfig,
+			// http.Transport only negotiates h2 with a custom config when forced
+			ForceAttemptHTTP2: hasProtocol(options.alpn, "h2"),
 		},
 	}, nil
 }
 
-func NewCertPool(pkiPath, ca string, system bool) (*x509.CertPool, error) {
-	path := filepath.Join(pkiPath, ca+".crt")
-	log.Printf("reading %q certificate from %q\n", ca, path)
-
-	data, err := ioutil.ReadFile(path)
+func NewCertPool(store KeyStore, ca string, system bool) (*x509.CertPool, error) {
+	chain, err := ReadCertChain(store, ca)
 	if err != nil {
 		return nil, fmt.Errorf("read ca cert: %v", err)
 	}
@@ -132,15 +255,15 @@ func NewCertPool(pkiPath, ca string, system bool) (*x509.CertPool, error) {
 		pool = x509.NewCertPool()
 	}
 
-	if !pool.AppendCertsFromPEM(data) {
-		return nil, fmt.Errorf("append ca cert to pool")
+	for _, cert := range chain {
+		pool.AddCert(cert)
 	}
 
 	return pool, nil
 }
 
-func NewTLSServer(pkiPath, ca, server, addr string, handler http.Handler) (*http.Server, error) {
-	tlsConfig, err := NewTLSConfig(pkiPath, ca, server)
+func NewTLSServer(store KeyStore, ca, server, addr string, handler http.Handler, opts ...TLSOption) (*http.Server, error) {
+	tlsConfig, err := NewTLSConfig(store, ca, server, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("create tls config: %v", err)
 	}
@@ -154,3 +277,12 @@ func NewTLSServer(pkiPath, ca, server, addr string, handler http.Handler) (*http
 		TLSConfig:    tlsConfig,
 	}, nil
 }
+
+func hasProtocol(protocols []string, protocol string) bool {
+	for _, p := range protocols {
+		if p == protocol {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,187 @@
+package cert
+
+// https://wiki.mozilla.org/Security/Server_Side_TLS
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"fmt"
+)
+
+// TLSPreset selects the protocol versions, the cipher suites and the curves
+// of the configs created by NewTLSConfig and NewTLSClient.
+type TLSPreset string
+
+const (
+	// PresetModern only allows TLS 1.3, for clients known to support it.
+	PresetModern TLSPreset = "modern"
+	// PresetIntermediate allows TLS 1.2 with forward secrecy and AEAD
+	// cipher suites, and TLS 1.3. It is the default.
+	PresetIntermediate TLSPreset = "intermediate"
+	// PresetLegacy allows TLS 1.0 and the CBC and RSA key exchange cipher
+	// suites, for old clients only.
+	PresetLegacy TLSPreset = "legacy"
+)
+
+// ClientAuthMode selects how a server built by NewTLSConfig authenticates
+// its clients, the mode is ignored by NewTLSClient.
+type ClientAuthMode string
+
+const (
+	// ClientAuthNone does not request client certificates.
+	ClientAuthNone ClientAuthMode = "none"
+	// ClientAuthOptional verifies the client certificates when given.
+	// It is the default.
+	ClientAuthOptional ClientAuthMode = "optional"
+	// ClientAuthRequired rejects the clients without a valid certificate.
+	ClientAuthRequired ClientAuthMode = "required"
+)
+
+var clientAuthTypes = map[ClientAuthMode]tls.ClientAuthType{
+	ClientAuthNone:     tls.NoClientCert,
+	ClientAuthOptional: tls.VerifyClientCertIfGiven,
+	ClientAuthRequired: tls.RequireAndVerifyClientCert,
+}
+
+// WithPreset selects the TLS preset, PresetIntermediate by default.
+func WithPreset(preset TLSPreset) TLSOption {
+	return func(o *tlsOptions) {
+		o.preset = preset
+	}
+}
+
+// WithClientAuth selects the client authentication mode of the server,
+// ClientAuthOptional by default.
+func WithClientAuth(mode ClientAuthMode) TLSOption {
+	return func(o *tlsOptions) {
+		o.clientAuth = mode
+	}
+}
+
+// WithALPN sets the application protocols, in order of preference.
+// The server defaults to h2, the client to the protocols of http.Transport.
+func WithALPN(protocols ...string) TLSOption {
+	return func(o *tlsOptions) {
+		o.alpn = protocols
+	}
+}
+
+// WithSessionResumption enables the session tickets of the server, and a
+// session cache of cacheSize entries on the client, 64 when zero.
+// Resumed sessions skip the verification of the certificate chains, but
+// not the CRL checks of WithCRL.
+func WithSessionResumption(cacheSize int) TLSOption {
+	return func(o *tlsOptions) {
+		o.resumption = true
+		o.sessionCacheSize = cacheSize
+	}
+}
+
+func newTLSOptions(opts []TLSOption) (tlsOptions, error) {
+	options := tlsOptions{
+		preset:     PresetIntermediate,
+		clientAuth: ClientAuthOptional,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	switch options.preset {
+	case PresetModern, PresetIntermediate, PresetLegacy:
+	default:
+		return options, fmt.Errorf("invalid tls preset: %q", options.preset)
+	}
+	if _, ok := clientAuthTypes[options.clientAuth]; !ok {
+		return options, fmt.Errorf("invalid client auth mode: %q", options.clientAuth)
+	}
+
+	return options, nil
+}
+
+// config returns the base config of the options, without certificates.
+func (o tlsOptions) config(server bool) (*tls.Config, error) {
+	// https://blog.gopheracademy.com/advent-2016/exposing-go-on-the-internet/
+	config := &tls.Config{
+		PreferServerCipherSuites: true,
+		SessionTicketsDisabled:   !o.resumption,
+		NextProtos:               o.alpn,
+	}
+
+	switch o.preset {
+	case PresetModern:
+		// the TLS 1.3 cipher suites are not configurable
+		config.MinVersion = tls.VersionTLS13
+		config.CurvePreferences = []tls.CurveID{
+			tls.X25519,
+			tls.CurveP256,
+			tls.CurveP384,
+		}
+
+	case PresetIntermediate:
+		config.MinVersion = tls.VersionTLS12
+		config.CurvePreferences = []tls.CurveID{
+			tls.CurveP256,
+			tls.X25519,
+		}
+		config.CipherSuites = []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305, // Go 1.8 only
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,   // Go 1.8 only
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		}
+
+	case PresetLegacy:
+		config.MinVersion = tls.VersionTLS10
+		config.CurvePreferences = []tls.CurveID{
+			tls.CurveP256,
+			tls.X25519,
+			tls.CurveP384,
+		}
+		config.CipherSuites = []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+			// no forward secrecy
+			tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+		}
+	}
+
+	if !server {
+		if o.resumption {
+			size := o.sessionCacheSize
+			if size == 0 {
+				size = 64
+			}
+			config.ClientSessionCache = tls.NewLRUClientSessionCache(size)
+		}
+		return config, nil
+	}
+
+	config.ClientAuth = clientAuthTypes[o.clientAuth]
+	if config.NextProtos == nil {
+		config.NextProtos = []string{"h2"}
+	}
+
+	if o.resumption {
+		// the configs cloned by GetConfigForClient share the ticket keys
+		var key [32]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return nil, fmt.Errorf("generate session ticket key: %v", err)
+		}
+		config.SetSessionTicketKeys([][32]byte{key})
+	}
+
+	return config, nil
+}
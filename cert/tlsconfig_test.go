@@ -0,0 +1,150 @@
+package cert
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestTLSPresets(t *testing.T) {
+	get := func(url string, opts ...TLSOption) (*http.Response, error) {
+		client, err := NewTLSClient(pki, "ca", "client", opts...)
+		if err != nil {
+			t.Fatalf("create client: %v", err)
+		}
+		res, err := client.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		return res, nil
+	}
+
+	withServerPKI(pki, func(url string) {
+		res, err := get(url, WithPreset(PresetModern))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.TLS.Version != tls.VersionTLS13 {
+			t.Fatalf("unexpected version: %x", res.TLS.Version)
+		}
+
+		client, err := NewTLSClient(pki, "ca", "client", WithPreset(PresetLegacy))
+		if err != nil {
+			t.Fatalf("create client: %v", err)
+		}
+		client.Transport.(*http.Transport).TLSClientConfig.MaxVersion = tls.VersionTLS12
+		if _, err := client.Get(url); err == nil {
+			t.Fatal("tls 1.2 accepted by the modern preset")
+		}
+	}, WithPreset(PresetModern))
+
+	withServerPKI(pki, func(url string) {
+		client, err := NewTLSClient(pki, "ca", "client", WithPreset(PresetLegacy))
+		if err != nil {
+			t.Fatalf("create client: %v", err)
+		}
+		config := client.Transport.(*http.Transport).TLSClientConfig
+		config.MaxVersion = tls.VersionTLS11
+		res, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.TLS.Version != tls.VersionTLS11 {
+			t.Fatalf("unexpected version: %x", res.TLS.Version)
+		}
+	}, WithPreset(PresetLegacy))
+
+	if _, err := NewTLSClient(pki, "ca", "client", WithPreset("paranoid")); err == nil {
+		t.Fatal("invalid preset accepted")
+	}
+	if _, err := NewTLSConfig(pki, "ca", "localhost", WithClientAuth("maybe")); err == nil {
+		t.Fatal("invalid client auth mode accepted")
+	}
+}
+
+func TestTLSClientAuthModes(t *testing.T) {
+	for mode, greetings := range map[ClientAuthMode][2]string{
+		ClientAuthNone:     {"hello world", "hello world"},
+		ClientAuthOptional: {"hello client@washingmachine", "hello world"},
+		ClientAuthRequired: {"hello client@washingmachine", ""},
+	} {
+		withServerPKI(pki, func(url string) {
+			client, err := NewTLSClient(pki, "ca", "client")
+			if err != nil {
+				t.Fatalf("create client: %v", err)
+			}
+
+			for i, greeting := range greetings {
+				if i == 1 {
+					client.CloseIdleConnections()
+					client.Transport.(*http.Transport).TLSClientConfig.Certificates = nil
+				}
+
+				res, err := client.Get(url)
+				if greeting == "" {
+					if err == nil {
+						t.Fatalf("%s: client without certificate accepted", mode)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("%s: %v", mode, err)
+				}
+				data, err := ioutil.ReadAll(res.Body)
+				res.Body.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != greeting {
+					t.Fatalf("%s: unexpected greeting: %q", mode, data)
+				}
+			}
+		}, WithClientAuth(mode))
+	}
+}
+
+func TestTLSALPN(t *testing.T) {
+	withServerPKI(pki, func(url string) {
+		client, err := NewTLSClient(pki, "ca", "client", WithALPN("h2"))
+		if err != nil {
+			t.Fatalf("create client: %v", err)
+		}
+		res, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.ProtoMajor != 2 || res.TLS.NegotiatedProtocol != "h2" {
+			t.Fatalf("unexpected protocol: %s %q", res.Proto, res.TLS.NegotiatedProtocol)
+		}
+	}, WithALPN("h2", "http/1.1"))
+}
+
+func TestTLSSessionResumption(t *testing.T) {
+	for _, preset := range []TLSPreset{PresetModern, PresetIntermediate} {
+		withServerPKI(pki, func(url string) {
+			client, err := NewTLSClient(pki, "ca", "client", WithPreset(preset), WithSessionResumption(0))
+			if err != nil {
+				t.Fatalf("create client: %v", err)
+			}
+
+			for i := 0; i < 2; i++ {
+				res, err := client.Get(url)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ioutil.ReadAll(res.Body)
+				res.Body.Close()
+				client.CloseIdleConnections()
+
+				if res.TLS.DidResume != (i == 1) {
+					t.Fatalf("%s: unexpected resumption of connection %d", preset, i)
+				}
+			}
+		}, WithPreset(preset), WithSessionResumption(0))
+	}
+}